@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/evleria/jwt-auth-demo/pkg/client"
+	"github.com/evleria/jwt-auth-demo/pkg/verifier"
+)
+
+func register(ctx context.Context, app *app, args []string) error {
+	flags := flag.NewFlagSet("register", flag.ContinueOnError)
+	firstName := flags.String("first", "", "first name")
+	lastName := flags.String("last", "", "last name")
+	email := flags.String("email", "", "email")
+	password := flags.String("password", "", "password, $AUTHCTL_PASSWORD or read from stdin by default")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	c, err := app.client()
+	if err != nil {
+		return err
+	}
+	request := client.RegisterRequest{
+		FirstName: *firstName,
+		LastName:  *lastName,
+		Email:     *email,
+		Password:  readPassword(*password),
+	}
+	if err := c.Register(ctx, request); err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "Registered", *email)
+	return nil
+}
+
+func login(ctx context.Context, app *app, args []string) error {
+	flags := flag.NewFlagSet("login", flag.ContinueOnError)
+	email := flags.String("email", "", "email")
+	password := flags.String("password", "", "password, $AUTHCTL_PASSWORD or read from stdin by default")
+	clientId := flags.String("client", app.config.ClientId, "client id")
+	clientSecret := flags.String("client-secret", os.Getenv("AUTHCTL_CLIENT_SECRET"), "secret of a confidential client, $AUTHCTL_CLIENT_SECRET by default")
+	scope := flags.String("scope", "", "requested scope")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	app.config.ClientId = *clientId
+	c, err := app.client()
+	if err != nil {
+		return err
+	}
+	tokens, err := c.Login(ctx, client.LoginRequest{
+		Email:        *email,
+		Password:     readPassword(*password),
+		ClientId:     *clientId,
+		ClientSecret: *clientSecret,
+		Scope:        *scope,
+	})
+	if err != nil {
+		return err
+	}
+	return app.print(tokens)
+}
+
+func refresh(ctx context.Context, app *app, args []string) error {
+	c, err := app.client()
+	if err != nil {
+		return err
+	}
+	tokens, err := c.Refresh(ctx)
+	if err != nil {
+		return err
+	}
+	return app.print(tokens)
+}
+
+func logout(ctx context.Context, app *app, args []string) error {
+	c, err := app.client()
+	if err != nil {
+		return err
+	}
+	return c.Logout(ctx)
+}
+
+// token prints the access token alone, refreshed first if it is about to
+// expire, so that it can be passed to other commands.
+func token(ctx context.Context, app *app, args []string) error {
+	c, err := app.client()
+	if err != nil {
+		return err
+	}
+	tokens, err := c.Tokens(ctx)
+	if err != nil {
+		return err
+	}
+	if !tokens.ExpiresAt.IsZero() && time.Until(tokens.ExpiresAt) < time.Second*30 {
+		if tokens, err = c.Refresh(ctx); err != nil {
+			return err
+		}
+	}
+	fmt.Println(tokens.AccessToken)
+	return nil
+}
+
+// decode prints the claims of a token without verifying it. JWTs and
+// v4.public PASETO tokens can be decoded; v4.local ones are encrypted.
+func decode(ctx context.Context, app *app, args []string) error {
+	flags := flag.NewFlagSet("decode", flag.ContinueOnError)
+	header := flags.Bool("header", false, "print the header (footer of PASETO tokens) instead of the claims")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	tokenString, err := app.tokenArg(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	var part []byte
+	switch parts := strings.Split(tokenString, "."); {
+	case len(parts) >= 3 && parts[0] == "v4" && parts[1] == "public":
+		if *header {
+			if len(parts) < 4 {
+				return errors.New("token has no footer")
+			}
+			part, err = base64.RawURLEncoding.DecodeString(parts[3])
+			break
+		}
+		part, err = base64.RawURLEncoding.DecodeString(parts[2])
+		if err == nil && len(part) < 64 {
+			err = errors.New("token is too short")
+		}
+		if err == nil {
+			part = part[:len(part)-64]
+		}
+	case len(parts) >= 3 && parts[0] == "v4" && parts[1] == "local":
+		return errors.New("v4.local tokens are encrypted")
+	case len(parts) == 3:
+		index := 1
+		if *header {
+			index = 0
+		}
+		part, err = base64.RawURLEncoding.DecodeString(parts[index])
+	default:
+		return errors.New("unknown token format")
+	}
+	if err != nil {
+		return fmt.Errorf("cannot decode token: %w", err)
+	}
+
+	var members map[string]interface{}
+	if err := json.Unmarshal(part, &members); err != nil {
+		return fmt.Errorf("cannot decode token: %w", err)
+	}
+	return app.print(members)
+}
+
+// verify verifies a JWT access token with the keys of the server and
+// prints its claims. PASETO tokens are not supported.
+func verify(ctx context.Context, app *app, args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	issuer := flags.String("issuer", app.config.Server, "expected issuer")
+	audience := flags.String("audience", "", "expected audience")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	tokenString, err := app.tokenArg(flags.Args())
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(tokenString, "v4.") {
+		return errors.New("only JWT access tokens can be verified, not PASETO ones")
+	}
+
+	v, err := verifier.New(verifier.Options{
+		Issuer:   *issuer,
+		Audience: *audience,
+		JwksURL:  strings.TrimSuffix(app.config.Server, "/") + "/.well-known/jwks.json",
+	})
+	if err != nil {
+		return err
+	}
+	claims, err := v.Verify(ctx, tokenString)
+	if err != nil {
+		return err
+	}
+	return app.print(claims)
+}
+
+// tokenArg is the token given as the argument, or the stored access token.
+func (a *app) tokenArg(args []string) (string, error) {
+	if len(args) > 0 {
+		return args[0], nil
+	}
+	if a.config.Tokens == nil {
+		return "", client.ErrNotLoggedIn
+	}
+	return a.config.Tokens.AccessToken, nil
+}
+
+func readPassword(password string) string {
+	if password != "" {
+		return password
+	}
+	if password := os.Getenv("AUTHCTL_PASSWORD"); password != "" {
+		return password
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimRight(line, "\r\n")
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/evleria/jwt-auth-demo/pkg/client"
+)
+
+const defaultServer = "http://localhost:5000"
+
+// config is the local config file of authctl. It holds the credentials of
+// the logged in session, so it is only readable by the user.
+type config struct {
+	path string
+
+	Server   string         `json:"server"`
+	ClientId string         `json:"clientId,omitempty"`
+	Tokens   *client.Tokens `json:"tokens,omitempty"`
+}
+
+// defaultConfigPath is $AUTHCTL_CONFIG, or authctl/config.json in the
+// user config directory.
+func defaultConfigPath() string {
+	if path := os.Getenv("AUTHCTL_CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ".authctl.json"
+	}
+	return filepath.Join(dir, "authctl", "config.json")
+}
+
+func loadConfig(path string) (*config, error) {
+	cfg := &config{path: path, Server: defaultServer}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *config) save() error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(c.path, data, 0600)
+}
+
+// The config file is the token store of the client, so that refreshed
+// tokens outlive the command.
+func (c *config) Load(_ context.Context) (*client.Tokens, error) {
+	return c.Tokens, nil
+}
+
+func (c *config) Save(_ context.Context, tokens *client.Tokens) error {
+	c.Tokens = tokens
+	return c.save()
+}
+
+func (c *config) Clear(_ context.Context) error {
+	c.Tokens = nil
+	return c.save()
+}
@@ -0,0 +1,101 @@
+// Command authctl is a command-line client of the auth API.
+//
+//	authctl [-server url] [-config path] [-o json|table] <command> [flags]
+//
+// After logging in once, an access token for curl is one command away:
+//
+//	curl -H "Authorization: Bearer $(authctl token)" http://localhost:5000/auth/me
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/evleria/jwt-auth-demo/pkg/client"
+)
+
+type app struct {
+	config *config
+	output string
+}
+
+type command struct {
+	usage string
+	run   func(ctx context.Context, app *app, args []string) error
+}
+
+var commands = map[string]command{
+	"register": {"register -first NAME -last NAME -email EMAIL [-password PASSWORD]", register},
+	"login":    {"login -email EMAIL [-password PASSWORD] [-client ID [-client-secret SECRET]] [-scope SCOPE]", login},
+	"refresh":  {"refresh", refresh},
+	"logout":   {"logout", logout},
+	"token":    {"token", token},
+	"decode":   {"decode [-header] [TOKEN]", decode},
+	"verify":   {"verify [-issuer URL] [-audience AUDIENCE] [JWT] (not PASETO)", verify},
+}
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "authctl:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	flags := flag.NewFlagSet("authctl", flag.ContinueOnError)
+	flags.Usage = func() { printUsage(flags) }
+	configPath := flags.String("config", defaultConfigPath(), "config file")
+	server := flags.String("server", "", "server URL, saved on login")
+	output := flags.String("o", "table", "output format: json or table")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *output != "json" && *output != "table" {
+		return fmt.Errorf("unknown output format %q", *output)
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("missing command")
+	}
+	cmd, ok := commands[flags.Arg(0)]
+	if !ok {
+		flags.Usage()
+		return fmt.Errorf("unknown command %q", flags.Arg(0))
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("cannot read config: %w", err)
+	}
+	if *server != "" {
+		cfg.Server = *server
+	}
+	return cmd.run(context.Background(), &app{config: cfg, output: *output}, flags.Args()[1:])
+}
+
+func printUsage(flags *flag.FlagSet) {
+	fmt.Fprintln(flags.Output(), "Usage: authctl [flags] <command> [command flags]\n\nCommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintln(flags.Output(), "  "+commands[name].usage)
+	}
+	fmt.Fprintln(flags.Output(), "\nFlags:")
+	flags.PrintDefaults()
+}
+
+func (a *app) client() (client.Client, error) {
+	return client.New(client.Options{BaseURL: a.config.Server, Store: a.config})
+}
+
+func (a *app) print(object interface{}) error {
+	return printObject(os.Stdout, a.output, object)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// printObject prints a JSON object either indented or as a table of its
+// members. Nested values are printed as compact JSON in the table.
+func printObject(w io.Writer, format string, object interface{}) error {
+	if format == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(object)
+	}
+
+	members, err := toMap(object)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(members))
+	for name := range members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, name := range names {
+		fmt.Fprintf(table, "%s\t%s\n", strings.ToUpper(name), formatValue(name, members[name]))
+	}
+	return table.Flush()
+}
+
+func toMap(object interface{}) (map[string]interface{}, error) {
+	if members, ok := object.(map[string]interface{}); ok {
+		return members, nil
+	}
+
+	data, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+	var members map[string]interface{}
+	if err := json.Unmarshal(data, &members); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+func formatValue(name string, value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		if name == "exp" || name == "iat" || name == "nbf" {
+			return fmt.Sprintf("%.0f (%s)", v, time.Unix(int64(v), 0).Local().Format(time.RFC3339))
+		}
+		return fmt.Sprintf("%v", v)
+	case []interface{}:
+		values := make([]string, len(v))
+		for i, item := range v {
+			values[i] = formatValue("", item)
+		}
+		return strings.Join(values, " ")
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
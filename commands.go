@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/evleria/jwt-auth-demo/internal/config"
+	"github.com/evleria/jwt-auth-demo/internal/handler"
+	"github.com/evleria/jwt-auth-demo/internal/jwt"
+	"github.com/evleria/jwt-auth-demo/internal/repository"
+	"gopkg.in/go-playground/validator.v9"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+//go:embed db/schema.sql
+var schema string
+
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"serve":               {"serve", func([]string) error { return serve() }},
+	"migrate":             {"migrate", migrate},
+	"user create":         {"user create -first NAME -last NAME [-password PASSWORD] [-roles ROLE,...] EMAIL", createUser},
+	"user reset-password": {"user reset-password [-password PASSWORD] EMAIL", resetPassword},
+	"user disable":        {"user disable EMAIL", disableUser},
+	"token revoke":        {"token revoke TOKEN | -session ID | -user EMAIL", revokeToken},
+	"keys generate":       {"keys generate [-alg ALGORITHM] [-out FILE]", generateKey},
+	"keys rotate":         {"keys rotate [-pid PID]", rotateKeys},
+}
+
+// runCommand runs the command named by the first one or two arguments, and
+// serves the API without any.
+func runCommand(args []string) error {
+	if len(args) == 0 {
+		return serve()
+	}
+	for n := 2; n >= 1; n-- {
+		if len(args) < n {
+			continue
+		}
+		if cmd, ok := commands[strings.Join(args[:n], " ")]; ok {
+			return cmd.run(args[n:])
+		}
+	}
+	flag.Usage()
+	return fmt.Errorf("unknown command %q", strings.Join(args, " "))
+}
+
+func printUsage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [-config FILE] [command]\n\nCommands:\n", filepath.Base(os.Args[0]))
+	usages := make([]string, 0, len(commands))
+	for _, cmd := range commands {
+		usages = append(usages, cmd.usage)
+	}
+	sort.Strings(usages)
+	for _, usage := range usages {
+		fmt.Fprintln(out, "  "+usage)
+	}
+	fmt.Fprintln(out, "\nFlags:")
+	flag.PrintDefaults()
+}
+
+// migrate applies db/schema.sql, which only creates what is missing.
+func migrate(args []string) error {
+	db, err := connectPostgres()
+	if err != nil {
+		return err
+	}
+	defer db.Close(context.Background())
+
+	_, err = db.Exec(context.Background(), schema)
+	if err != nil {
+		return fmt.Errorf("cannot migrate: %w", err)
+	}
+	fmt.Println("schema is up to date")
+	return nil
+}
+
+func createUser(args []string) error {
+	flags := flag.NewFlagSet("user create", flag.ContinueOnError)
+	firstName := flags.String("first", "", "first name")
+	lastName := flags.String("last", "", "last name")
+	password := flags.String("password", "", "password, read from stdin by default")
+	roles := flags.String("roles", "", "comma separated roles to assign")
+	email, err := parseUserArgs(flags, args)
+	if err != nil {
+		return err
+	}
+
+	request := handler.RegisterRequest{
+		FirstName: *firstName,
+		LastName:  *lastName,
+		Email:     email,
+		Password:  readPassword(*password),
+	}
+	if err := validator.New().Struct(request); err != nil {
+		return err
+	}
+
+	services, err := connectServices()
+	if err != nil {
+		return err
+	}
+	err = services.auth.Register(request.FirstName, request.LastName, request.Email, request.Password)
+	if err != nil {
+		return err
+	}
+	user, err := services.userRepository.GetUserByEmail(email)
+	if err != nil {
+		return err
+	}
+	for _, role := range strings.Split(*roles, ",") {
+		if role = strings.TrimSpace(role); role == "" {
+			continue
+		}
+		if err := services.rbac.AssignRole(user.Id, role); err != nil {
+			return fmt.Errorf("cannot assign role %s: %w", role, err)
+		}
+	}
+
+	fmt.Printf("created user %d\n", user.Id)
+	return nil
+}
+
+func resetPassword(args []string) error {
+	flags := flag.NewFlagSet("user reset-password", flag.ContinueOnError)
+	password := flags.String("password", "", "new password, read from stdin by default")
+	email, err := parseUserArgs(flags, args)
+	if err != nil {
+		return err
+	}
+
+	newPassword := readPassword(*password)
+	if err := validator.New().Var(newPassword, "required,min=8,max=30"); err != nil {
+		return errors.New("password must be 8 to 30 characters long")
+	}
+
+	services, user, err := connectServicesForUser(email)
+	if err != nil {
+		return err
+	}
+	if err := services.auth.SetPassword(user.Id, newPassword); err != nil {
+		return err
+	}
+	fmt.Printf("password of user %d reset, sessions revoked\n", user.Id)
+	return nil
+}
+
+func disableUser(args []string) error {
+	flags := flag.NewFlagSet("user disable", flag.ContinueOnError)
+	email, err := parseUserArgs(flags, args)
+	if err != nil {
+		return err
+	}
+
+	services, user, err := connectServicesForUser(email)
+	if err != nil {
+		return err
+	}
+	if err := services.auth.DisableUser(user.Id); err != nil {
+		return err
+	}
+	fmt.Printf("user %d disabled, sessions revoked\n", user.Id)
+	return nil
+}
+
+func revokeToken(args []string) error {
+	flags := flag.NewFlagSet("token revoke", flag.ContinueOnError)
+	sessionId := flags.String("session", "", "revoke a session and its tokens")
+	email := flags.String("user", "", "revoke every token of a user")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	services, err := connectServices()
+	if err != nil {
+		return err
+	}
+	switch {
+	case *email != "":
+		user, err := services.userRepository.GetUserByEmail(*email)
+		if err != nil {
+			return fmt.Errorf("cannot find user %s", *email)
+		}
+		if err := services.auth.LogoutAll(user.Id); err != nil {
+			return err
+		}
+		fmt.Printf("tokens of user %d revoked\n", user.Id)
+	case *sessionId != "":
+		if err := services.auth.RevokeSession(*sessionId); err != nil {
+			return err
+		}
+		fmt.Printf("session %s revoked\n", *sessionId)
+	case flags.NArg() == 1:
+		active, err := services.oauth.RevokeToken(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		if !active {
+			return errors.New("token is not active")
+		}
+		fmt.Println("token revoked")
+	default:
+		return errors.New("a token, -session or -user is required")
+	}
+	return nil
+}
+
+func generateKey(args []string) error {
+	flags := flag.NewFlagSet("keys generate", flag.ContinueOnError)
+	algorithm := flags.String("alg", config.GetString("ACCESS_TOKEN_ALGORITHM", "HS256"), "signing algorithm")
+	out := flags.String("out", "", "file to write the key to, stdout by default")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	key, err := jwt.GenerateKey(*algorithm)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		fmt.Println(strings.TrimSpace(string(key)))
+		return nil
+	}
+	return writeKeyFile(*out, key)
+}
+
+// rotateKeys generates new keys for the access and the refresh tokens and
+// signals the server to load them. Private keys are written to their key
+// files and HMAC secrets to the config file, which the server reloads, so
+// that every instance sharing them switches to the same keys; each one has
+// to be signaled. The server keeps accepting tokens signed by the previous
+// keys until they expire.
+func rotateKeys(args []string) error {
+	flags := flag.NewFlagSet("keys rotate", flag.ContinueOnError)
+	pid := flags.Int("pid", 0, "process id of the server, read from PID_FILE by default")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	prefixes := []string{"ACCESS_TOKEN", "REFRESH_TOKEN"}
+	for _, prefix := range prefixes {
+		name := prefix + "_SECRET"
+		if strings.HasPrefix(config.GetString(prefix+"_ALGORITHM", "HS256"), "HS") && config.IsSetByEnvironment(name) {
+			return fmt.Errorf("%s is set by the environment, it cannot be rotated through %s", name, cfgPath)
+		}
+	}
+
+	rotated := make(map[string]bool)
+	for _, prefix := range prefixes {
+		algorithm := config.GetString(prefix+"_ALGORITHM", "HS256")
+		key, err := jwt.GenerateKey(algorithm)
+		if err != nil {
+			return err
+		}
+
+		if strings.HasPrefix(algorithm, "HS") {
+			name := prefix + "_SECRET"
+			if err := config.Set(cfgPath, name, string(key)); err != nil {
+				return err
+			}
+			fmt.Printf("generated a new %s secret in %s\n", algorithm, name)
+			continue
+		}
+
+		path := config.GetString(prefix+"_PRIVATE_KEY", "")
+		if path == "" {
+			return fmt.Errorf("%s requires %s_PRIVATE_KEY", algorithm, prefix)
+		}
+		if rotated[path] {
+			continue
+		}
+		if err := writeKeyFile(path, key); err != nil {
+			return err
+		}
+		rotated[path] = true
+		fmt.Printf("generated a new %s key in %s\n", algorithm, path)
+	}
+
+	if *pid == 0 {
+		data, err := os.ReadFile(config.GetString("PID_FILE", filepath.Join(os.TempDir(), "jwt-auth-demo.pid")))
+		if err != nil {
+			return fmt.Errorf("cannot find the server: %w", err)
+		}
+		if *pid, err = strconv.Atoi(strings.TrimSpace(string(data))); err != nil {
+			return fmt.Errorf("cannot find the server: %w", err)
+		}
+	}
+	server, err := os.FindProcess(*pid)
+	if err != nil {
+		return fmt.Errorf("cannot find the server: %w", err)
+	}
+	if err := server.Signal(syscall.SIGHUP); err != nil {
+		return fmt.Errorf("cannot signal the server: %w", err)
+	}
+	fmt.Printf("signaled server %d to rotate its keys\n", *pid)
+	return nil
+}
+
+// writeKeyFile replaces the file at once, so that the server never loads a
+// half written key.
+func writeKeyFile(path string, key []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, key, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func connectServices() (*services, error) {
+	db, err := connectPostgres()
+	if err != nil {
+		return nil, err
+	}
+	redisClient, err := connectRedis()
+	if err != nil {
+		return nil, err
+	}
+	jwtMaker, err := jwt.NewMakerFromConfig()
+	if err != nil {
+		return nil, err
+	}
+	return newServices(db, redisClient, jwtMaker)
+}
+
+func connectServicesForUser(email string) (*services, *repository.User, error) {
+	services, err := connectServices()
+	if err != nil {
+		return nil, nil, err
+	}
+	user, err := services.userRepository.GetUserByEmail(email)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot find user %s", email)
+	}
+	return services, user, nil
+}
+
+// parseUserArgs parses the flags of a command taking the email of a user
+// as its only argument.
+func parseUserArgs(flags *flag.FlagSet, args []string) (string, error) {
+	if err := flags.Parse(args); err != nil {
+		return "", err
+	}
+	if flags.NArg() != 1 {
+		return "", errors.New("the email of the user is required")
+	}
+	return flags.Arg(0), nil
+}
+
+func readPassword(password string) string {
+	if password != "" {
+		return password
+	}
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimRight(line, "\r\n")
+}
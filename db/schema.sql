@@ -4,9 +4,69 @@ CREATE TABLE IF NOT EXISTS users
     first_name VARCHAR(20) NOT NULL,
     last_name  VARCHAR(20) NOT NULL,
     email      VARCHAR(50) UNIQUE NOT NULL,
-    pass_hash  VARCHAR(60) NOT NULL
+    pass_hash  VARCHAR(60) NOT NULL,
+    scopes     VARCHAR(50)[] NOT NULL DEFAULT '{}',
+    disabled   BOOLEAN NOT NULL DEFAULT FALSE
 );
 
+ALTER TABLE users ADD COLUMN IF NOT EXISTS scopes VARCHAR(50)[] NOT NULL DEFAULT '{}';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS roles
+(
+    id   SERIAL PRIMARY KEY,
+    name VARCHAR(50) UNIQUE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS permissions
+(
+    id   SERIAL PRIMARY KEY,
+    name VARCHAR(50) UNIQUE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions
+(
+    role_id       INT NOT NULL,
+    permission_id INT NOT NULL,
+    PRIMARY KEY (role_id, permission_id),
+    CONSTRAINT fk_role
+    FOREIGN KEY (role_id) REFERENCES roles(id) ON DELETE CASCADE,
+    CONSTRAINT fk_permission
+    FOREIGN KEY (permission_id) REFERENCES permissions(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS user_roles
+(
+    user_id INT NOT NULL,
+    role_id INT NOT NULL,
+    PRIMARY KEY (user_id, role_id),
+    CONSTRAINT fk_user
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    CONSTRAINT fk_role
+    FOREIGN KEY (role_id) REFERENCES roles(id) ON DELETE CASCADE
+);
+
+INSERT INTO roles (name) VALUES ('admin') ON CONFLICT DO NOTHING;
+INSERT INTO permissions (name) VALUES ('roles:read'), ('roles:write') ON CONFLICT DO NOTHING;
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE r.name = 'admin' AND p.name IN ('roles:read', 'roles:write')
+ON CONFLICT DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS clients
+(
+    id          VARCHAR(50) PRIMARY KEY,
+    name        VARCHAR(50) NOT NULL,
+    secret_hash VARCHAR(60),
+    audiences   VARCHAR(100)[] NOT NULL DEFAULT '{}',
+    session_idle_timeout INT,
+    session_max_lifetime INT
+);
+
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS secret_hash VARCHAR(60);
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS session_idle_timeout INT;
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS session_max_lifetime INT;
+
 CREATE TABLE IF NOT EXISTS lists
 (
     id      SERIAL PRIMARY KEY,
@@ -6,22 +6,107 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 )
 
+// fileKeys are the keys set from the config file rather than by the
+// environment of the process.
+var fileKeys = make(map[string]bool)
+
+// Load sets the values of the config file that the environment does not
+// set already.
 func Load(path string) error {
-	absPath, err := filepath.Abs(path)
+	values, err := read(path)
 	if err != nil {
-		return fmt.Errorf("cannot load config: %w", err)
+		return err
 	}
 
-	if err := godotenv.Load(absPath); err != nil {
-		return fmt.Errorf("cannot load config: %w", err)
+	for key, value := range values {
+		if _, ok := os.LookupEnv(key); ok {
+			continue
+		}
+		os.Setenv(key, value)
+		fileKeys[key] = true
 	}
+	return nil
+}
 
+// Reload reads the config file loaded before again, so that a running
+// process can pick up changes. The environment still takes precedence, and
+// keys removed from the file are unset.
+func Reload(path string) error {
+	values, err := read(path)
+	if err != nil {
+		return err
+	}
+
+	for key := range fileKeys {
+		if _, ok := values[key]; !ok {
+			os.Unsetenv(key)
+			delete(fileKeys, key)
+		}
+	}
+	for key, value := range values {
+		if _, ok := os.LookupEnv(key); ok && !fileKeys[key] {
+			continue
+		}
+		os.Setenv(key, value)
+		fileKeys[key] = true
+	}
 	return nil
 }
 
+// Set writes a value to the config file, replacing the line of the key or
+// appending one. The file is rewritten in place, as it may be mounted into
+// a container on its own.
+func Set(path, key, value string) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot update config: %w", err)
+	}
+
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(data) == 0 {
+		lines = nil
+	}
+	found := false
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), key+"=") {
+			lines[i] = key + "=" + value
+			found = true
+		}
+	}
+	if !found {
+		lines = append(lines, key+"="+value)
+	}
+
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		return fmt.Errorf("cannot update config: %w", err)
+	}
+	return nil
+}
+
+// IsSetByEnvironment tells whether the key is set by the environment of the
+// process, which the config file cannot override.
+func IsSetByEnvironment(key string) bool {
+	_, ok := os.LookupEnv(key)
+	return ok && !fileKeys[key]
+}
+
+func read(path string) (map[string]string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load config: %w", err)
+	}
+
+	values, err := godotenv.Read(absPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load config: %w", err)
+	}
+	return values, nil
+}
+
 func GetString(key string, fallback string) string {
 	return lookup(key, fallback)
 }
@@ -42,6 +127,21 @@ func GetBool(key string, fallback bool) bool {
 	return fallback
 }
 
+func GetStrings(key string, fallback []string) []string {
+	value := lookup(key, "")
+	if value == "" {
+		return fallback
+	}
+
+	values := make([]string, 0)
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
 func GetDuration(key string, fallback time.Duration) time.Duration {
 	value := lookup(key, "")
 	if value, err := time.ParseDuration(value); err == nil {
@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"errors"
+	"github.com/evleria/jwt-auth-demo/internal/service"
+	"github.com/labstack/echo/v4"
+	"gopkg.in/go-playground/validator.v9"
+	"net/http"
+	"strconv"
+)
+
+type Admin interface {
+	Roles(context echo.Context) error
+	CreateRole(context echo.Context) error
+	UserRoles(context echo.Context) error
+	AssignRole(context echo.Context) error
+	UnassignRole(context echo.Context) error
+}
+
+type admin struct {
+	validate *validator.Validate
+	service  service.RBAC
+}
+
+func NewAdminHandler(service service.RBAC) Admin {
+	return &admin{
+		validate: validator.New(),
+		service:  service,
+	}
+}
+
+// Roles godoc
+// @Tags Admin
+// @Summary Lists the roles and the permissions they grant
+// @Security BearerAuth
+// @Success 200 {array} RoleResponse
+// @Failure 401 {object} DefaultHttpError
+// @Failure 403 {object} DefaultHttpError
+// @Failure 500 {object} DefaultHttpError
+// @Router /admin/roles [get]
+func (c *admin) Roles(ctx echo.Context) error {
+	roles, err := c.service.GetRoles()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	response := make([]RoleResponse, 0, len(roles))
+	for _, role := range roles {
+		response = append(response, RoleResponse{
+			Name:        role.Name,
+			Permissions: role.Permissions,
+		})
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// CreateRole godoc
+// @Tags Admin
+// @Summary Creates a role
+// @Security BearerAuth
+// @Param roleData body CreateRoleRequest true "Role information"
+// @Success 201 "Created"
+// @Failure 400 {object} DefaultHttpError
+// @Failure 401 {object} DefaultHttpError
+// @Failure 403 {object} DefaultHttpError
+// @Failure 409 {object} DefaultHttpError
+// @Failure 500 {object} DefaultHttpError
+// @Router /admin/roles [post]
+func (c *admin) CreateRole(ctx echo.Context) error {
+	request := new(CreateRoleRequest)
+	err := ctx.Bind(request)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest)
+	}
+	err = validateRequest(c.validate, request)
+	if err != nil {
+		return err
+	}
+
+	err = c.service.CreateRole(request.Name, request.Permissions)
+	if errors.Is(err, service.ErrAlreadyExists) {
+		return echo.NewHTTPError(http.StatusConflict, "role already exists")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	return ctx.NoContent(http.StatusCreated)
+}
+
+// UserRoles godoc
+// @Tags Admin
+// @Summary Lists the roles assigned to a user
+// @Security BearerAuth
+// @Param id path int true "User id"
+// @Success 200 {array} string
+// @Failure 401 {object} DefaultHttpError
+// @Failure 403 {object} DefaultHttpError
+// @Failure 404 {object} DefaultHttpError
+// @Failure 500 {object} DefaultHttpError
+// @Router /admin/users/{id}/roles [get]
+func (c *admin) UserRoles(ctx echo.Context) error {
+	userId, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+
+	roles, err := c.service.GetUserRoles(userId)
+	if errors.Is(err, service.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	return ctx.JSON(http.StatusOK, roles)
+}
+
+// AssignRole godoc
+// @Tags Admin
+// @Summary Assigns a role to a user
+// @Description The role takes effect when the user refreshes their tokens.
+// @Security BearerAuth
+// @Param id path int true "User id"
+// @Param role path string true "Role name"
+// @Success 204 "No Content"
+// @Failure 401 {object} DefaultHttpError
+// @Failure 403 {object} DefaultHttpError
+// @Failure 404 {object} DefaultHttpError
+// @Failure 500 {object} DefaultHttpError
+// @Router /admin/users/{id}/roles/{role} [put]
+func (c *admin) AssignRole(ctx echo.Context) error {
+	return c.changeRole(ctx, c.service.AssignRole)
+}
+
+// UnassignRole godoc
+// @Tags Admin
+// @Summary Takes a role away from a user
+// @Description The role is dropped when the user refreshes their tokens.
+// @Security BearerAuth
+// @Param id path int true "User id"
+// @Param role path string true "Role name"
+// @Success 204 "No Content"
+// @Failure 401 {object} DefaultHttpError
+// @Failure 403 {object} DefaultHttpError
+// @Failure 404 {object} DefaultHttpError
+// @Failure 500 {object} DefaultHttpError
+// @Router /admin/users/{id}/roles/{role} [delete]
+func (c *admin) UnassignRole(ctx echo.Context) error {
+	return c.changeRole(ctx, c.service.UnassignRole)
+}
+
+func (c *admin) changeRole(ctx echo.Context, change func(userId int, role string) error) error {
+	userId, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+
+	err = change(userId, ctx.Param("role"))
+	if errors.Is(err, service.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+type RoleResponse struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+}
+
+type CreateRoleRequest struct {
+	Name        string   `json:"name" validate:"required,max=50"`
+	Permissions []string `json:"permissions" validate:"dive,required,max=50"`
+}
@@ -1,29 +1,46 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
+	"github.com/evleria/jwt-auth-demo/internal/jwt"
 	"github.com/evleria/jwt-auth-demo/internal/service"
 	"github.com/labstack/echo/v4"
 	"gopkg.in/go-playground/validator.v9"
 	"net/http"
+	"net/url"
 	"strings"
+	"time"
 )
 
 type Auth interface {
 	Register(context echo.Context) error
 	Login(context echo.Context) error
 	Refresh(context echo.Context) error
+	Me(context echo.Context) error
+	Sessions(context echo.Context) error
+	DeleteSession(context echo.Context) error
+	Logout(context echo.Context) error
+	LogoutAll(context echo.Context) error
+	ForgotPassword(context echo.Context) error
+	ResetPassword(context echo.Context) error
 }
 
 type auth struct {
-	validate *validator.Validate
-	service  service.Auth
+	validate      *validator.Validate
+	service       service.Auth
+	oauth         service.OAuth
+	dpop          service.DPoP
+	passwordReset service.PasswordReset
 }
 
-func NewAuthHandler(service service.Auth) Auth {
+func NewAuthHandler(service service.Auth, oauth service.OAuth, dpop service.DPoP, passwordReset service.PasswordReset) Auth {
 	return &auth{
-		validate: validator.New(),
-		service:  service,
+		validate:      validator.New(),
+		service:       service,
+		oauth:         oauth,
+		dpop:          dpop,
+		passwordReset: passwordReset,
 	}
 }
 
@@ -57,9 +74,13 @@ func (c *auth) Register(ctx echo.Context) error {
 // Login godoc
 // @Tags Auth
 // @Summary Logins a user
+// @Description A confidential client authenticates with HTTP Basic credentials or its client secret, a public client is identified by its id alone.
+// @Description A DPoP proof or a TLS client certificate binds the issued tokens to the key of the client.
 // @Param loginData body LoginRequest true "Login information"
+// @Param DPoP header string false "DPoP proof"
 // @Success 200 {object} LoginResponse
 // @Failure 400 {object} DefaultHttpError
+// @Failure 401 {object} DefaultHttpError
 // @Failure 500 {object} DefaultHttpError
 // @Router /auth/login [post]
 func (c *auth) Login(ctx echo.Context) error {
@@ -72,7 +93,15 @@ func (c *auth) Login(ctx echo.Context) error {
 	if err != nil {
 		return err
 	}
-	accessToken, refreshToken, err := c.service.Login(request.Email, request.Password)
+	clientId, err := c.authenticateClient(ctx, request)
+	if err != nil {
+		return err
+	}
+	cnf, err := getConfirmation(ctx, c.dpop)
+	if err != nil {
+		return err
+	}
+	accessToken, refreshToken, err := c.service.Login(request.Email, request.Password, clientId, request.Scope, ctx.Request().UserAgent(), cnf)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError)
 	}
@@ -80,6 +109,7 @@ func (c *auth) Login(ctx echo.Context) error {
 	response := &LoginResponse{
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
+		TokenType:    getTokenType(cnf),
 	}
 
 	return ctx.JSON(http.StatusOK, response)
@@ -88,7 +118,9 @@ func (c *auth) Login(ctx echo.Context) error {
 // Refresh godoc
 // @Tags Auth
 // @Summary Refresh a user
+// @Description Refresh tokens issued with a DPoP proof or a TLS client certificate require the same key.
 // @Param refreshData body RefreshRequest true "Refresh information"
+// @Param DPoP header string false "DPoP proof"
 // @Success 200 {object} RefreshResponse
 // @Failure 400 {object} DefaultHttpError
 // @Failure 500 {object} DefaultHttpError
@@ -100,19 +132,239 @@ func (c *auth) Refresh(ctx echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest)
 	}
 
-	accessToken, err := c.service.Refresh(request.RefreshToken)
+	cnf, err := getConfirmation(ctx, c.dpop)
+	if err != nil {
+		return err
+	}
+	accessToken, refreshToken, err := c.service.Refresh(request.RefreshToken, cnf)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError)
 	}
 
 	response := RefreshResponse{
-		AccessToken: accessToken,
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    getTokenType(cnf),
 	}
 	return ctx.JSON(http.StatusOK, response)
 }
 
+// Me godoc
+// @Tags Auth
+// @Summary Returns the authenticated user
+// @Security BearerAuth
+// @Success 200 {object} MeResponse
+// @Failure 401 {object} DefaultHttpError
+// @Failure 500 {object} DefaultHttpError
+// @Router /auth/me [get]
+func (c *auth) Me(ctx echo.Context) error {
+	claims := GetClaims(ctx)
+
+	user, err := c.service.GetUser(claims.Subject)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	response := MeResponse{
+		Id:        user.Id,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		Email:     user.Email,
+		Roles:     claims.Roles,
+		Scope:     claims.Scope,
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// Sessions godoc
+// @Tags Auth
+// @Summary Lists the sessions of the authenticated user
+// @Security BearerAuth
+// @Success 200 {array} SessionResponse
+// @Failure 401 {object} DefaultHttpError
+// @Failure 500 {object} DefaultHttpError
+// @Router /auth/sessions [get]
+func (c *auth) Sessions(ctx echo.Context) error {
+	claims := GetClaims(ctx)
+
+	sessions, err := c.service.GetSessions(claims.Subject)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	response := make([]SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, SessionResponse{
+			Id:         session.Id,
+			ClientId:   session.ClientId,
+			Device:     session.Device,
+			CreatedAt:  session.CreatedAt,
+			LastUsedAt: session.LastUsedAt,
+			ExpiresAt:  session.ExpiresAt,
+			Current:    session.Id == claims.SessionId,
+		})
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// DeleteSession godoc
+// @Tags Auth
+// @Summary Revokes a session of the authenticated user
+// @Security BearerAuth
+// @Param id path string true "Session id"
+// @Success 204 "No Content"
+// @Failure 401 {object} DefaultHttpError
+// @Failure 404 {object} DefaultHttpError
+// @Failure 500 {object} DefaultHttpError
+// @Router /auth/sessions/{id} [delete]
+func (c *auth) DeleteSession(ctx echo.Context) error {
+	claims := GetClaims(ctx)
+
+	err := c.service.DeleteSession(claims.Subject, ctx.Param("id"))
+	if errors.Is(err, service.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// Logout godoc
+// @Tags Auth
+// @Summary Revokes the current session
+// @Security BearerAuth
+// @Success 204 "No Content"
+// @Failure 401 {object} DefaultHttpError
+// @Failure 500 {object} DefaultHttpError
+// @Router /auth/logout [post]
+func (c *auth) Logout(ctx echo.Context) error {
+	claims := GetClaims(ctx)
+
+	err := c.service.Logout(claims)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// LogoutAll godoc
+// @Tags Auth
+// @Summary Revokes every token issued to the authenticated user
+// @Security BearerAuth
+// @Success 204 "No Content"
+// @Failure 401 {object} DefaultHttpError
+// @Failure 500 {object} DefaultHttpError
+// @Router /auth/logout-all [post]
+func (c *auth) LogoutAll(ctx echo.Context) error {
+	claims := GetClaims(ctx)
+
+	err := c.service.LogoutAll(claims.Subject)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// ForgotPassword godoc
+// @Tags Auth
+// @Summary Mails a password reset token to a user
+// @Description The response is the same whether the email is registered or not.
+// @Param forgotPasswordData body ForgotPasswordRequest true "Email of the user"
+// @Success 202 "Accepted"
+// @Failure 400 {object} DefaultHttpError
+// @Failure 500 {object} DefaultHttpError
+// @Router /auth/password/forgot [post]
+func (c *auth) ForgotPassword(ctx echo.Context) error {
+	request := new(ForgotPasswordRequest)
+	err := ctx.Bind(request)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest)
+	}
+	err = c.Validate(request)
+	if err != nil {
+		return err
+	}
+
+	err = c.passwordReset.ForgotPassword(request.Email)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	return ctx.NoContent(http.StatusAccepted)
+}
+
+// ResetPassword godoc
+// @Tags Auth
+// @Summary Sets a new password with a reset token
+// @Description Reset tokens can be used once. Every session of the user is revoked.
+// @Param resetPasswordData body ResetPasswordRequest true "Reset token and new password"
+// @Success 204 "No Content"
+// @Failure 400 {object} DefaultHttpError
+// @Failure 500 {object} DefaultHttpError
+// @Router /auth/password/reset [post]
+func (c *auth) ResetPassword(ctx echo.Context) error {
+	request := new(ResetPasswordRequest)
+	err := ctx.Bind(request)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest)
+	}
+	err = c.Validate(request)
+	if err != nil {
+		return err
+	}
+
+	err = c.passwordReset.ResetPassword(request.Token, request.Password)
+	if errors.Is(err, service.ErrInvalidResetToken) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// getTokenType is the authorization scheme an access token is used with.
+func getTokenType(cnf *jwt.Confirmation) string {
+	if cnf != nil && cnf.JwkThumbprint != "" {
+		return "DPoP"
+	}
+	return "Bearer"
+}
+
+// authenticateClient authenticates the client a user logs in with, given
+// either HTTP Basic credentials or the client id and secret of the request.
+// Logging in without a client yields an empty client id.
+func (c *auth) authenticateClient(ctx echo.Context, request *LoginRequest) (string, error) {
+	clientId, clientSecret, ok := ctx.Request().BasicAuth()
+	if ok {
+		clientId, _ = url.QueryUnescape(clientId)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientId, clientSecret = request.ClientId, request.ClientSecret
+	}
+	if clientId == "" && clientSecret == "" {
+		return "", nil
+	}
+
+	client, err := c.oauth.AuthenticateClient(clientId, clientSecret)
+	if err != nil {
+		ctx.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="oauth"`)
+		return "", echo.NewHTTPError(http.StatusUnauthorized, "client authentication failed")
+	}
+	return client.Id, nil
+}
+
 func (c *auth) Validate(input interface{}) error {
-	err := c.validate.Struct(input)
+	return validateRequest(c.validate, input)
+}
+
+func validateRequest(validate *validator.Validate, input interface{}) error {
+	err := validate.Struct(input)
 	if err != nil {
 		validationErrs := err.(validator.ValidationErrors)
 		fields := make([]string, 0, len(validationErrs))
@@ -136,13 +388,26 @@ type RegisterRequest struct {
 }
 
 type LoginRequest struct {
-	Email    string `json:"email" validate:"required,email"`
-	Password string `json:"password" validate:"required,min=8,max=30"`
+	Email        string `json:"email" validate:"required,email"`
+	Password     string `json:"password" validate:"required,min=8,max=30"`
+	ClientId     string `json:"clientId" validate:"max=50"`
+	ClientSecret string `json:"clientSecret" validate:"max=100"`
+	Scope        string `json:"scope" validate:"max=500"`
 }
 
 type LoginResponse struct {
 	AccessToken  string `json:"accessToken"`
 	RefreshToken string `json:"refreshToken"`
+	TokenType    string `json:"tokenType"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" validate:"required,max=100"`
+	Password string `json:"password" validate:"required,min=8,max=30"`
 }
 
 type RefreshRequest struct {
@@ -150,5 +415,26 @@ type RefreshRequest struct {
 }
 
 type RefreshResponse struct {
-	AccessToken string `json:"accessToken"`
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	TokenType    string `json:"tokenType"`
+}
+
+type MeResponse struct {
+	Id        int      `json:"id"`
+	FirstName string   `json:"firstName"`
+	LastName  string   `json:"lastName"`
+	Email     string   `json:"email"`
+	Roles     []string `json:"roles"`
+	Scope     string   `json:"scope"`
+}
+
+type SessionResponse struct {
+	Id         string    `json:"id"`
+	ClientId   string    `json:"clientId"`
+	Device     string    `json:"device"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	Current    bool      `json:"current"`
 }
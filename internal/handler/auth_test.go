@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"errors"
+	"github.com/evleria/jwt-auth-demo/internal/repository"
+	"github.com/evleria/jwt-auth-demo/internal/service"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type memoryClientRepository map[string]*repository.Client
+
+func (r memoryClientRepository) GetClientById(id string) (*repository.Client, error) {
+	client, ok := r[id]
+	if !ok {
+		return nil, errors.New("no rows in result set")
+	}
+	return client, nil
+}
+
+func TestLoginAuthenticatesClient(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secretHash := string(hash)
+	clients := memoryClientRepository{
+		"spa":     {Id: "spa"},
+		"backend": {Id: "backend", SecretHash: &secretHash, Audiences: []string{"billing"}},
+	}
+	controller := &auth{oauth: service.NewOAuthService(clients, nil, nil, 0)}
+
+	tests := []struct {
+		name     string
+		request  LoginRequest
+		basic    []string
+		clientId string
+		status   int
+	}{
+		{name: "no client"},
+		{name: "public client by id", request: LoginRequest{ClientId: "spa"}, clientId: "spa"},
+		{name: "public client with a secret", request: LoginRequest{ClientId: "spa", ClientSecret: "secret"}, status: http.StatusUnauthorized},
+		{name: "confidential client without a secret", request: LoginRequest{ClientId: "backend"}, status: http.StatusUnauthorized},
+		{name: "confidential client with a wrong secret", request: LoginRequest{ClientId: "backend", ClientSecret: "guess"}, status: http.StatusUnauthorized},
+		{name: "confidential client with its secret", request: LoginRequest{ClientId: "backend", ClientSecret: "secret"}, clientId: "backend"},
+		{name: "confidential client with basic credentials", basic: []string{"backend", "secret"}, clientId: "backend"},
+		{name: "basic credentials take precedence", request: LoginRequest{ClientId: "spa"}, basic: []string{"backend", "guess"}, status: http.StatusUnauthorized},
+		{name: "unknown client", request: LoginRequest{ClientId: "unknown"}, status: http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/auth/login", nil)
+			if test.basic != nil {
+				request.SetBasicAuth(test.basic[0], test.basic[1])
+			}
+			ctx := echo.New().NewContext(request, httptest.NewRecorder())
+
+			clientId, err := controller.authenticateClient(ctx, &test.request)
+			if test.status == 0 {
+				if err != nil {
+					t.Fatalf("got error %v, want none", err)
+				}
+				if clientId != test.clientId {
+					t.Fatalf("got client %q, want %q", clientId, test.clientId)
+				}
+				return
+			}
+			var httpErr *echo.HTTPError
+			if !errors.As(err, &httpErr) || httpErr.Code != test.status {
+				t.Fatalf("got error %v, want status %d", err, test.status)
+			}
+		})
+	}
+}
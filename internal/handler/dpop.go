@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/evleria/jwt-auth-demo/internal/jwt"
+	"github.com/evleria/jwt-auth-demo/internal/service"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strings"
+)
+
+const (
+	dpopHeader      = "DPoP"
+	dpopNonceHeader = "DPoP-Nonce"
+)
+
+// getDPoPConfirmation verifies the DPoP proof sent to a token endpoint and
+// returns the confirmation binding the issued tokens to its key, or nil if
+// the request has no proof.
+func getDPoPConfirmation(ctx echo.Context, dpop service.DPoP) (*jwt.Confirmation, error) {
+	proofs := ctx.Request().Header.Values(dpopHeader)
+	if len(proofs) == 0 {
+		return nil, nil
+	}
+	if len(proofs) > 1 {
+		return nil, oauthError(http.StatusBadRequest, "invalid_dpop_proof", "only one DPoP proof is allowed")
+	}
+
+	proof, err := dpop.VerifyProof(proofs[0], ctx.Request().Method, getRequestUrl(ctx), "")
+	setDPoPNonce(ctx, dpop)
+	if errors.Is(err, service.ErrDPoPNonceRequired) {
+		return nil, oauthError(http.StatusBadRequest, "use_dpop_nonce", "DPoP proof requires a nonce")
+	}
+	if err != nil {
+		return nil, oauthError(http.StatusBadRequest, "invalid_dpop_proof", "invalid DPoP proof")
+	}
+	return &jwt.Confirmation{JwkThumbprint: proof.JwkThumbprint}, nil
+}
+
+// verifyDPoPProof verifies the DPoP proof sent with a DPoP-bound access
+// token to a protected resource.
+func verifyDPoPProof(ctx echo.Context, dpop service.DPoP, accessToken string, claims *jwt.Claims) error {
+	proofs := ctx.Request().Header.Values(dpopHeader)
+	if len(proofs) != 1 {
+		return dpopUnauthorized(ctx, "invalid_dpop_proof", "missing DPoP proof")
+	}
+
+	proof, err := dpop.VerifyProof(proofs[0], ctx.Request().Method, getRequestUrl(ctx), accessToken)
+	if errors.Is(err, service.ErrDPoPNonceRequired) {
+		setDPoPNonce(ctx, dpop)
+		return dpopUnauthorized(ctx, "use_dpop_nonce", "DPoP proof requires a nonce")
+	}
+	if err != nil || proof.JwkThumbprint != claims.Confirmation.JwkThumbprint {
+		return dpopUnauthorized(ctx, "invalid_dpop_proof", "invalid DPoP proof")
+	}
+	return nil
+}
+
+func setDPoPNonce(ctx echo.Context, dpop service.DPoP) {
+	if nonce := dpop.Nonce(); nonce != "" {
+		ctx.Response().Header().Set(dpopNonceHeader, nonce)
+	}
+}
+
+func dpopUnauthorized(ctx echo.Context, code, message string) error {
+	ctx.Response().Header().Set(echo.HeaderWWWAuthenticate,
+		fmt.Sprintf(`DPoP error="%s", algs="%s"`, code, strings.Join(jwt.DPoPAlgorithms(), " ")))
+	return echo.NewHTTPError(http.StatusUnauthorized, message)
+}
+
+// getRequestUrl is the URL DPoP proofs are expected to be issued for.
+func getRequestUrl(ctx echo.Context) string {
+	return fmt.Sprintf("%s://%s%s", ctx.Scheme(), ctx.Request().Host, ctx.Request().URL.Path)
+}
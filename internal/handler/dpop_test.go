@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"errors"
+	"github.com/evleria/jwt-auth-demo/internal/jwt"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// stubDPoP accepts every proof as one signed by the key with the thumbprint.
+type stubDPoP struct {
+	thumbprint string
+}
+
+func (s *stubDPoP) VerifyProof(proof, method, url, accessToken string) (*jwt.DPoPProof, error) {
+	return &jwt.DPoPProof{Id: proof, Method: method, URL: url, JwkThumbprint: s.thumbprint}, nil
+}
+
+func (s *stubDPoP) Nonce() string {
+	return ""
+}
+
+func TestVerifyDPoPProof(t *testing.T) {
+	claims := &jwt.Claims{Confirmation: &jwt.Confirmation{JwkThumbprint: "bound-key"}}
+
+	tests := []struct {
+		name       string
+		proofs     []string
+		thumbprint string
+		status     int
+	}{
+		{name: "proof key matches cnf.jkt", proofs: []string{"proof"}, thumbprint: "bound-key"},
+		{name: "proof key does not match cnf.jkt", proofs: []string{"proof"}, thumbprint: "other-key", status: http.StatusUnauthorized},
+		{name: "missing proof", thumbprint: "bound-key", status: http.StatusUnauthorized},
+		{name: "several proofs", proofs: []string{"proof", "proof"}, thumbprint: "bound-key", status: http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/auth/me", nil)
+			for _, proof := range test.proofs {
+				request.Header.Add(dpopHeader, proof)
+			}
+			ctx := echo.New().NewContext(request, httptest.NewRecorder())
+
+			err := verifyDPoPProof(ctx, &stubDPoP{thumbprint: test.thumbprint}, "access-token", claims)
+			if test.status == 0 {
+				if err != nil {
+					t.Fatalf("got error %v, want none", err)
+				}
+				return
+			}
+			var httpErr *echo.HTTPError
+			if !errors.As(err, &httpErr) || httpErr.Code != test.status {
+				t.Fatalf("got error %v, want status %d", err, test.status)
+			}
+			if ctx.Response().Header().Get(echo.HeaderWWWAuthenticate) == "" {
+				t.Fatal("missing WWW-Authenticate challenge")
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/evleria/jwt-auth-demo/internal/jwt"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type Keys interface {
+	Jwks(context echo.Context) error
+	Discovery(context echo.Context) error
+}
+
+type keys struct {
+	jwtMaker      jwt.Maker
+	baseUrl       string
+	cacheDuration time.Duration
+	mutualTLS     bool
+}
+
+// NewKeysHandler makes the handler of the key and metadata endpoints. The
+// endpoints are advertised under publicUrl, or under the issuer if that is
+// empty, and never under the host a request names, which the client
+// controls.
+func NewKeysHandler(jwtMaker jwt.Maker, publicUrl string, cacheDuration time.Duration, mutualTLS bool) Keys {
+	if publicUrl == "" && isHttpUrl(jwtMaker.Issuer()) {
+		publicUrl = jwtMaker.Issuer()
+	}
+	return &keys{
+		jwtMaker:      jwtMaker,
+		baseUrl:       strings.TrimSuffix(publicUrl, "/"),
+		cacheDuration: cacheDuration,
+		mutualTLS:     mutualTLS,
+	}
+}
+
+// Jwks godoc
+// @Tags Keys
+// @Summary Returns the public keys used to verify issued tokens
+// @Success 200 {object} jwt.JSONWebKeySet
+// @Router /.well-known/jwks.json [get]
+func (c *keys) Jwks(ctx echo.Context) error {
+	c.setCacheHeaders(ctx)
+	return ctx.JSON(http.StatusOK, c.jwtMaker.PublicKeys())
+}
+
+// Discovery godoc
+// @Tags Keys
+// @Summary Returns the authorization server metadata
+// @Success 200 {object} DiscoveryResponse
+// @Failure 404 {object} DefaultHttpError
+// @Router /.well-known/oauth-authorization-server [get]
+func (c *keys) Discovery(ctx echo.Context) error {
+	if c.baseUrl == "" {
+		return echo.NewHTTPError(http.StatusNotFound, "PUBLIC_URL is not configured")
+	}
+	baseUrl := c.baseUrl
+
+	response := DiscoveryResponse{
+		Issuer:                           c.jwtMaker.Issuer(),
+		JwksUri:                          baseUrl + "/.well-known/jwks.json",
+		TokenEndpoint:                    baseUrl + "/oauth/token",
+		TokenEndpointAuthMethods:         clientAuthMethods,
+		GrantTypes:                       []string{tokenExchangeGrantType},
+		IntrospectionEndpoint:            baseUrl + "/oauth/introspect",
+		IntrospectionEndpointAuthMethods: clientAuthMethods,
+		RevocationEndpoint:               baseUrl + "/oauth/revoke",
+		RevocationEndpointAuthMethods:    append([]string{"none"}, clientAuthMethods...),
+		DPoPSigningAlgorithms:            jwt.DPoPAlgorithms(),
+		CertificateBoundAccessTokens:     c.mutualTLS,
+	}
+
+	c.setCacheHeaders(ctx)
+	return ctx.JSON(http.StatusOK, response)
+}
+
+func (c *keys) setCacheHeaders(ctx echo.Context) {
+	ctx.Response().Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(c.cacheDuration.Seconds())))
+}
+
+func isHttpUrl(rawUrl string) bool {
+	u, err := url.Parse(rawUrl)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+var clientAuthMethods = []string{"client_secret_basic", "client_secret_post"}
+
+type DiscoveryResponse struct {
+	Issuer                           string   `json:"issuer"`
+	JwksUri                          string   `json:"jwks_uri"`
+	TokenEndpoint                    string   `json:"token_endpoint"`
+	TokenEndpointAuthMethods         []string `json:"token_endpoint_auth_methods_supported"`
+	GrantTypes                       []string `json:"grant_types_supported"`
+	IntrospectionEndpoint            string   `json:"introspection_endpoint"`
+	IntrospectionEndpointAuthMethods []string `json:"introspection_endpoint_auth_methods_supported"`
+	RevocationEndpoint               string   `json:"revocation_endpoint"`
+	RevocationEndpointAuthMethods    []string `json:"revocation_endpoint_auth_methods_supported"`
+	DPoPSigningAlgorithms            []string `json:"dpop_signing_alg_values_supported"`
+	CertificateBoundAccessTokens     bool     `json:"tls_client_certificate_bound_access_tokens"`
+}
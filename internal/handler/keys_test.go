@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/evleria/jwt-auth-demo/internal/jwt"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type stubMaker struct {
+	jwt.Maker
+	issuer string
+}
+
+func (m *stubMaker) Issuer() string {
+	return m.issuer
+}
+
+func TestDiscoveryIgnoresRequestHost(t *testing.T) {
+	tests := []struct {
+		name      string
+		publicUrl string
+		issuer    string
+		jwksUri   string
+	}{
+		{name: "public url", publicUrl: "https://auth.example.com/", issuer: "jwt-auth-demo", jwksUri: "https://auth.example.com/.well-known/jwks.json"},
+		{name: "public url over the issuer", publicUrl: "https://auth.example.com", issuer: "https://issuer.example.com", jwksUri: "https://auth.example.com/.well-known/jwks.json"},
+		{name: "issuer url", issuer: "https://issuer.example.com", jwksUri: "https://issuer.example.com/.well-known/jwks.json"},
+		{name: "neither", issuer: "jwt-auth-demo"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			controller := NewKeysHandler(&stubMaker{issuer: test.issuer}, test.publicUrl, time.Minute, false)
+			request := httptest.NewRequest(http.MethodGet, "/.well-known/oauth-authorization-server", nil)
+			request.Host = "attacker.example.com"
+			recorder := httptest.NewRecorder()
+			ctx := echo.New().NewContext(request, recorder)
+
+			err := controller.Discovery(ctx)
+			if test.jwksUri == "" {
+				var httpErr *echo.HTTPError
+				if !errors.As(err, &httpErr) || httpErr.Code != http.StatusNotFound {
+					t.Fatalf("got error %v, want status %d", err, http.StatusNotFound)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error %v, want none", err)
+			}
+			var response DiscoveryResponse
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if response.JwksUri != test.jwksUri {
+				t.Fatalf("got jwks_uri %q, want %q", response.JwksUri, test.jwksUri)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/evleria/jwt-auth-demo/internal/jwt"
+	"github.com/evleria/jwt-auth-demo/internal/service"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strings"
+)
+
+const claimsContextKey = "claims"
+
+// NewAuthMiddleware authenticates requests by their access token. Tokens
+// bound to a DPoP key are only accepted with the DPoP scheme and a proof of
+// the key, unbound ones only with the Bearer scheme. Tokens bound to a TLS
+// client certificate are only accepted over connections authenticated by
+// that certificate.
+func NewAuthMiddleware(service service.Auth, dpop service.DPoP, audience string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			scheme, accessToken, ok := getAccessToken(ctx)
+			if !ok {
+				return unauthorized(ctx, "missing access token")
+			}
+
+			claims, err := service.Authenticate(accessToken, audience)
+			if err != nil {
+				return unauthorized(ctx, "invalid access token")
+			}
+
+			if claims.IsDPoPBound() {
+				if scheme != dpopHeader {
+					return dpopUnauthorized(ctx, "invalid_token", "access token requires a DPoP proof")
+				}
+				if err := verifyDPoPProof(ctx, dpop, accessToken, claims); err != nil {
+					return err
+				}
+			} else if scheme != "Bearer" {
+				return unauthorized(ctx, "access token is not bound to a DPoP key")
+			}
+			if claims.IsCertificateBound() && getCertificateThumbprint(ctx) != claims.Confirmation.CertificateThumbprint {
+				return unauthorized(ctx, "access token is bound to another certificate")
+			}
+
+			ctx.Set(claimsContextKey, claims)
+			return next(ctx)
+		}
+	}
+}
+
+// RequireScope allows only access tokens granted every one of the scopes.
+// It has to run after the auth middleware.
+func RequireScope(scopes ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			claims := GetClaims(ctx)
+			if claims == nil {
+				return unauthorized(ctx, "missing access token")
+			}
+			for _, scope := range scopes {
+				if !claims.HasScope(scope) {
+					ctx.Response().Header().Set(echo.HeaderWWWAuthenticate,
+						fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, strings.Join(scopes, " ")))
+					return echo.NewHTTPError(http.StatusForbidden, "insufficient scope")
+				}
+			}
+			return next(ctx)
+		}
+	}
+}
+
+// RequireRole allows only access tokens of users having any of the roles.
+// It has to run after the auth middleware.
+func RequireRole(roles ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			claims := GetClaims(ctx)
+			if claims == nil {
+				return unauthorized(ctx, "missing access token")
+			}
+			for _, role := range roles {
+				if claims.HasRole(role) {
+					return next(ctx)
+				}
+			}
+			return echo.NewHTTPError(http.StatusForbidden, "insufficient role")
+		}
+	}
+}
+
+// RequirePermission allows only access tokens of users granted every one of
+// the permissions by their roles. It has to run after the auth middleware.
+func RequirePermission(permissions ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			claims := GetClaims(ctx)
+			if claims == nil {
+				return unauthorized(ctx, "missing access token")
+			}
+			for _, permission := range permissions {
+				if !claims.HasPermission(permission) {
+					return echo.NewHTTPError(http.StatusForbidden, "insufficient permissions")
+				}
+			}
+			return next(ctx)
+		}
+	}
+}
+
+func GetClaims(ctx echo.Context) *jwt.Claims {
+	claims, _ := ctx.Get(claimsContextKey).(*jwt.Claims)
+	return claims
+}
+
+// getAccessToken returns the access token of the Authorization header along
+// with its scheme, either Bearer or DPoP.
+func getAccessToken(ctx echo.Context) (string, string, bool) {
+	header := ctx.Request().Header.Get(echo.HeaderAuthorization)
+	parts := strings.SplitN(header, " ", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", "", false
+	}
+	switch {
+	case strings.EqualFold(parts[0], "Bearer"):
+		return "Bearer", parts[1], true
+	case strings.EqualFold(parts[0], dpopHeader):
+		return dpopHeader, parts[1], true
+	default:
+		return "", "", false
+	}
+}
+
+func unauthorized(ctx echo.Context, message string) error {
+	ctx.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+	return echo.NewHTTPError(http.StatusUnauthorized, message)
+}
@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"github.com/evleria/jwt-auth-demo/internal/jwt"
+	"github.com/evleria/jwt-auth-demo/internal/service"
+	"github.com/labstack/echo/v4"
+)
+
+// getConfirmation returns the confirmation binding the tokens issued by a
+// token endpoint to the DPoP key and the TLS client certificate of the
+// request, or nil if the request has neither.
+func getConfirmation(ctx echo.Context, dpop service.DPoP) (*jwt.Confirmation, error) {
+	cnf, err := getDPoPConfirmation(ctx, dpop)
+	if err != nil {
+		return nil, err
+	}
+
+	if thumbprint := getCertificateThumbprint(ctx); thumbprint != "" {
+		if cnf == nil {
+			cnf = &jwt.Confirmation{}
+		}
+		cnf.CertificateThumbprint = thumbprint
+	}
+	return cnf, nil
+}
+
+// getCertificateThumbprint returns the thumbprint of the client certificate
+// the TLS connection of the request has been verified with, if any.
+func getCertificateThumbprint(ctx echo.Context) string {
+	state := ctx.Request().TLS
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return jwt.CertificateThumbprint(state.VerifiedChains[0][0])
+}
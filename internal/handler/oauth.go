@@ -0,0 +1,258 @@
+package handler
+
+import (
+	"errors"
+	"github.com/evleria/jwt-auth-demo/internal/jwt"
+	"github.com/evleria/jwt-auth-demo/internal/repository"
+	"github.com/evleria/jwt-auth-demo/internal/service"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	accessTokenTokenType   = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+type OAuth interface {
+	Introspect(context echo.Context) error
+	Revoke(context echo.Context) error
+	Token(context echo.Context) error
+}
+
+type oauth struct {
+	service service.OAuth
+	dpop    service.DPoP
+}
+
+func NewOAuthHandler(service service.OAuth, dpop service.DPoP) OAuth {
+	return &oauth{
+		service: service,
+		dpop:    dpop,
+	}
+}
+
+// Introspect godoc
+// @Tags OAuth
+// @Summary Introspects a token (RFC 7662)
+// @Security BasicAuth
+// @Accept x-www-form-urlencoded
+// @Param token formData string true "Token to introspect"
+// @Param token_type_hint formData string false "access_token or refresh_token"
+// @Success 200 {object} IntrospectionResponse
+// @Failure 400 {object} OAuthError
+// @Failure 401 {object} OAuthError
+// @Router /oauth/introspect [post]
+func (c *oauth) Introspect(ctx echo.Context) error {
+	client, err := c.authenticateClient(ctx)
+	if err != nil {
+		return err
+	}
+	if !client.IsConfidential() {
+		return oauthError(http.StatusUnauthorized, "invalid_client", "only confidential clients can introspect tokens")
+	}
+
+	token := ctx.FormValue("token")
+	if token == "" {
+		return oauthError(http.StatusBadRequest, "invalid_request", "token is required")
+	}
+
+	introspection := c.service.Introspect(token, ctx.FormValue("token_type_hint"))
+	return ctx.JSON(http.StatusOK, newIntrospectionResponse(introspection))
+}
+
+// Revoke godoc
+// @Tags OAuth
+// @Summary Revokes an access or a refresh token (RFC 7009)
+// @Security BasicAuth
+// @Accept x-www-form-urlencoded
+// @Param token formData string true "Token to revoke"
+// @Param token_type_hint formData string false "access_token or refresh_token"
+// @Param client_id formData string false "Client id of public clients"
+// @Success 200 "OK"
+// @Failure 400 {object} OAuthError
+// @Failure 401 {object} OAuthError
+// @Failure 500 {object} OAuthError
+// @Router /oauth/revoke [post]
+func (c *oauth) Revoke(ctx echo.Context) error {
+	client, err := c.authenticateClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	token := ctx.FormValue("token")
+	if token == "" {
+		return oauthError(http.StatusBadRequest, "invalid_request", "token is required")
+	}
+
+	err = c.service.Revoke(client, token, ctx.FormValue("token_type_hint"))
+	if err != nil {
+		return oauthError(http.StatusInternalServerError, "server_error", "cannot revoke token")
+	}
+
+	return ctx.NoContent(http.StatusOK)
+}
+
+// Token godoc
+// @Tags OAuth
+// @Summary Exchanges an access token for another one (RFC 8693)
+// @Description Only the token exchange grant is supported. Without an actor token the client impersonates the subject, with one the new token records the actor in the act claim. The subject and actor tokens must be issued to or for the client, and one bound to a key can only be exchanged with a proof of the same key. The new token carries no roles or permissions, only the granted scope.
+// @Security BasicAuth
+// @Accept x-www-form-urlencoded
+// @Param grant_type formData string true "urn:ietf:params:oauth:grant-type:token-exchange"
+// @Param subject_token formData string true "Access token to exchange"
+// @Param subject_token_type formData string true "urn:ietf:params:oauth:token-type:access_token"
+// @Param actor_token formData string false "Access token of the acting party"
+// @Param actor_token_type formData string false "urn:ietf:params:oauth:token-type:access_token"
+// @Param audience formData []string false "Audiences of the new token"
+// @Param scope formData string false "Scope of the new token"
+// @Param DPoP header string false "DPoP proof"
+// @Success 200 {object} TokenExchangeResponse
+// @Failure 400 {object} OAuthError
+// @Failure 401 {object} OAuthError
+// @Failure 500 {object} OAuthError
+// @Router /oauth/token [post]
+func (c *oauth) Token(ctx echo.Context) error {
+	client, err := c.authenticateClient(ctx)
+	if err != nil {
+		return err
+	}
+	if !client.IsConfidential() {
+		return oauthError(http.StatusUnauthorized, "invalid_client", "only confidential clients can exchange tokens")
+	}
+
+	if ctx.FormValue("grant_type") != tokenExchangeGrantType {
+		return oauthError(http.StatusBadRequest, "unsupported_grant_type", "only token exchange is supported")
+	}
+	if ctx.FormValue("subject_token") == "" || ctx.FormValue("subject_token_type") != accessTokenTokenType {
+		return oauthError(http.StatusBadRequest, "invalid_request", "subject_token must be an access token")
+	}
+	if ctx.FormValue("actor_token") != "" && ctx.FormValue("actor_token_type") != accessTokenTokenType {
+		return oauthError(http.StatusBadRequest, "invalid_request", "actor_token must be an access token")
+	}
+	form, err := ctx.FormParams()
+	if err != nil {
+		return oauthError(http.StatusBadRequest, "invalid_request", "cannot parse the request")
+	}
+
+	cnf, err := getConfirmation(ctx, c.dpop)
+	if err != nil {
+		return err
+	}
+
+	accessToken, claims, err := c.service.ExchangeToken(client, &service.TokenExchange{
+		SubjectToken: ctx.FormValue("subject_token"),
+		ActorToken:   ctx.FormValue("actor_token"),
+		Audience:     form["audience"],
+		Scope:        ctx.FormValue("scope"),
+		Confirmation: cnf,
+	})
+	switch {
+	case errors.Is(err, service.ErrInvalidGrant):
+		return oauthError(http.StatusBadRequest, "invalid_grant", "subject or actor token is invalid")
+	case errors.Is(err, service.ErrInvalidTarget):
+		return oauthError(http.StatusBadRequest, "invalid_target", "audience is not allowed")
+	case err != nil:
+		return oauthError(http.StatusInternalServerError, "server_error", "cannot exchange token")
+	}
+
+	ctx.Response().Header().Set("Cache-Control", "no-store")
+	return ctx.JSON(http.StatusOK, TokenExchangeResponse{
+		AccessToken:     accessToken,
+		IssuedTokenType: accessTokenTokenType,
+		TokenType:       getTokenType(cnf),
+		ExpiresIn:       int64(time.Until(claims.ExpiresAtTime()).Seconds()),
+		Scope:           claims.Scope,
+	})
+}
+
+// authenticateClient authenticates the calling client with either HTTP
+// Basic credentials or client_id and client_secret form parameters.
+func (c *oauth) authenticateClient(ctx echo.Context) (*repository.Client, error) {
+	clientId, clientSecret, ok := ctx.Request().BasicAuth()
+	if ok {
+		clientId, _ = url.QueryUnescape(clientId)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientId = ctx.FormValue("client_id")
+		clientSecret = ctx.FormValue("client_secret")
+	}
+
+	client, err := c.service.AuthenticateClient(clientId, clientSecret)
+	if err != nil {
+		ctx.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="oauth"`)
+		return nil, oauthError(http.StatusUnauthorized, "invalid_client", "client authentication failed")
+	}
+	return client, nil
+}
+
+// oauthError makes an error rendered with an RFC 6749 error response body.
+func oauthError(status int, code, description string) error {
+	return echo.NewHTTPError(status, OAuthError{
+		Error:            code,
+		ErrorDescription: description,
+	})
+}
+
+func newIntrospectionResponse(introspection *service.Introspection) IntrospectionResponse {
+	if !introspection.Active {
+		return IntrospectionResponse{Active: false}
+	}
+
+	response := IntrospectionResponse{
+		Active: true,
+	}
+	if claims := introspection.Claims; claims != nil {
+		response.TokenType = getTokenType(claims.Confirmation)
+		response.Confirmation = claims.Confirmation
+		response.Actor = claims.Actor
+		response.Subject = strconv.Itoa(claims.Subject)
+		response.Username = claims.Email
+		response.ClientId = claims.ClientId
+		response.Scope = claims.Scope
+		response.ExpiresAt = claims.ExpiresAt
+		response.IssuedAt = claims.IssuedAt
+		response.NotBefore = claims.NotBefore
+		response.Audience = claims.Audience
+		response.Issuer = claims.Issuer
+		response.TokenId = claims.Id
+	}
+	if session := introspection.Session; session != nil {
+		response.Subject = strconv.Itoa(session.UserId)
+		response.ClientId = session.ClientId
+	}
+	return response
+}
+
+type OAuthError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+type IntrospectionResponse struct {
+	Active       bool              `json:"active"`
+	Scope        string            `json:"scope,omitempty"`
+	ClientId     string            `json:"client_id,omitempty"`
+	Username     string            `json:"username,omitempty"`
+	TokenType    string            `json:"token_type,omitempty"`
+	ExpiresAt    int64             `json:"exp,omitempty"`
+	IssuedAt     int64             `json:"iat,omitempty"`
+	NotBefore    int64             `json:"nbf,omitempty"`
+	Subject      string            `json:"sub,omitempty"`
+	Audience     jwt.Audience      `json:"aud,omitempty"`
+	Issuer       string            `json:"iss,omitempty"`
+	TokenId      string            `json:"jti,omitempty"`
+	Confirmation *jwt.Confirmation `json:"cnf,omitempty"`
+	Actor        *jwt.Actor        `json:"act,omitempty"`
+}
+
+type TokenExchangeResponse struct {
+	AccessToken     string `json:"access_token"`
+	IssuedTokenType string `json:"issued_token_type"`
+	TokenType       string `json:"token_type"`
+	ExpiresIn       int64  `json:"expires_in"`
+	Scope           string `json:"scope,omitempty"`
+}
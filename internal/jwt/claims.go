@@ -0,0 +1,28 @@
+package jwt
+
+import (
+	"crypto/x509"
+
+	"github.com/evleria/jwt-auth-demo/pkg/jose"
+)
+
+// The claims live in pkg/jose, so that services verifying the tokens decode
+// them the same way the server does.
+type (
+	Claims       = jose.Claims
+	Confirmation = jose.Confirmation
+	Actor        = jose.Actor
+	Audience     = jose.Audience
+)
+
+// IsRegisteredClaim tells whether the claim has a dedicated field in Claims
+// and therefore cannot be set through Extra.
+func IsRegisteredClaim(name string) bool {
+	return jose.IsRegisteredClaim(name)
+}
+
+// CertificateThumbprint computes the "x5t#S256" confirmation of a client
+// certificate.
+func CertificateThumbprint(certificate *x509.Certificate) string {
+	return jose.CertificateThumbprint(certificate)
+}
@@ -0,0 +1,97 @@
+package jwt
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+
+	jwtgo "github.com/dgrijalva/jwt-go"
+)
+
+const dpopProofType = "dpop+jwt"
+
+var dpopAlgorithms = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// jwkPrivateMembers are the JWK members that only private keys have.
+var jwkPrivateMembers = []string{"d", "p", "q", "dp", "dq", "qi", "oth", "k"}
+
+// DPoPProof is the claim set of a DPoP proof (RFC 9449).
+type DPoPProof struct {
+	Id              string `json:"jti"`
+	Method          string `json:"htm"`
+	URL             string `json:"htu"`
+	IssuedAt        int64  `json:"iat"`
+	AccessTokenHash string `json:"ath,omitempty"`
+	Nonce           string `json:"nonce,omitempty"`
+	// JwkThumbprint is the thumbprint of the key the proof is signed with.
+	JwkThumbprint string `json:"-"`
+}
+
+func (p *DPoPProof) Valid() error {
+	return nil
+}
+
+// DPoPAlgorithms lists the algorithms DPoP proofs can be signed with.
+func DPoPAlgorithms() []string {
+	return append([]string(nil), dpopAlgorithms...)
+}
+
+// ParseDPoPProof verifies a DPoP proof against the public key in its
+// header. Checking the claims against the request is up to the caller.
+func ParseDPoPProof(proof string) (*DPoPProof, error) {
+	claims := new(DPoPProof)
+	parser := &jwtgo.Parser{ValidMethods: dpopAlgorithms, SkipClaimsValidation: true}
+	_, err := parser.ParseWithClaims(proof, claims, func(t *jwtgo.Token) (interface{}, error) {
+		if typ, _ := t.Header["typ"].(string); typ != dpopProofType {
+			return nil, errors.New("unexpected proof type")
+		}
+		key, err := getDPoPKey(t)
+		if err != nil {
+			return nil, err
+		}
+		claims.JwkThumbprint = key.id
+		return key.verifyKey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Id == "" || claims.Method == "" || claims.URL == "" || claims.IssuedAt == 0 {
+		return nil, errors.New("proof misses required claims")
+	}
+	return claims, nil
+}
+
+// AccessTokenHash computes the "ath" claim proofs carry for an access
+// token.
+func AccessTokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func getDPoPKey(t *jwtgo.Token) (*signingKey, error) {
+	members, ok := t.Header["jwk"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("proof has no jwk")
+	}
+	for _, member := range jwkPrivateMembers {
+		if _, ok := members[member]; ok {
+			return nil, errors.New("proof jwk is not a public key")
+		}
+	}
+
+	data, err := json.Marshal(members)
+	if err != nil {
+		return nil, err
+	}
+	var jwk JSONWebKey
+	if err := json.Unmarshal(data, &jwk); err != nil {
+		return nil, err
+	}
+	publicKey, err := jwk.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	return newVerificationKey(t.Method, publicKey)
+}
@@ -0,0 +1,16 @@
+package jwt
+
+import (
+	"crypto"
+
+	"github.com/evleria/jwt-auth-demo/pkg/jose"
+)
+
+type (
+	JSONWebKeySet = jose.JSONWebKeySet
+	JSONWebKey    = jose.JSONWebKey
+)
+
+func NewJSONWebKey(publicKey crypto.PublicKey) (JSONWebKey, error) {
+	return jose.NewJSONWebKey(publicKey)
+}
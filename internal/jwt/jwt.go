@@ -2,6 +2,7 @@ package jwt
 
 import (
 	"errors"
+	"fmt"
 	"github.com/evleria/jwt-auth-demo/internal/config"
 	"time"
 
@@ -9,46 +10,173 @@ import (
 	gonanoid "github.com/matoous/go-nanoid/v2"
 )
 
+const (
+	accessTokenType  = "at+jwt"
+	refreshTokenType = "rt+jwt"
+)
+
 type Maker interface {
-	GenerateAccessToken(userId int, email string) (string, error)
-	GenerateRefreshToken(userId int) (string, error)
-	VerifyRefreshToken(refreshToken string) (jwtgo.MapClaims, error)
+	GenerateAccessToken(claims *Claims) (string, error)
+	GenerateRefreshToken(claims *Claims) (string, error)
+	VerifyAccessToken(accessToken string, audience string) (*Claims, error)
+	VerifyRefreshToken(refreshToken string) (*Claims, error)
+	Issuer() string
+	Audience() string
+	AccessTokenLifetime() time.Duration
+	PublicKeys() JSONWebKeySet
+	RotateKeys() error
+}
+
+// tokenFormat encodes claims into tokens and decodes them back, verifying
+// the signature with the key ring of the token type.
+type tokenFormat interface {
+	// check tells whether the format can sign tokens with the key.
+	check(key *signingKey) error
+	sign(claims *Claims, tokenType string, key *signingKey) (string, error)
+	parse(token string, tokenType string, keys *keyRing) (*Claims, error)
 }
 
 type maker struct {
-	accessTokenSecret    string
+	format               tokenFormat
+	issuer               string
+	audience             string
+	leeway               time.Duration
+	accessTokenKeys      *keyRing
 	accessTokenDuration  time.Duration
-	refreshTokenSecret   string
+	refreshTokenKeys     *keyRing
 	refreshTokenDuration time.Duration
 }
 
-func NewJwtMakerFromConfig() Maker {
+// NewMakerFromConfig makes a Maker issuing tokens in the TOKEN_FORMAT
+// format, either jwt or paseto.
+func NewMakerFromConfig() (Maker, error) {
+	// Retired keys keep verifying tokens for as long as the tokens they have
+	// signed are accepted, clock skew included.
+	leeway := config.GetDuration("TOKEN_LEEWAY", time.Second*30)
+	accessTokenDuration := config.GetDuration("ACCESS_TOKEN_DURATION", time.Minute*5)
+	accessTokenKeys, err := newKeyRingFromConfig("ACCESS_TOKEN", "access_secret", accessTokenDuration+leeway)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load access token keys: %w", err)
+	}
+
+	refreshTokenDuration := config.GetDuration("REFRESH_TOKEN_DURATION", time.Hour*24*7)
+	refreshTokenKeys, err := newKeyRingFromConfig("REFRESH_TOKEN", "refresh_secret", refreshTokenDuration+leeway)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load refresh token keys: %w", err)
+	}
+
+	var format tokenFormat
+	switch tokenFormat := config.GetString("TOKEN_FORMAT", "jwt"); tokenFormat {
+	case "jwt":
+		format = jwtFormat{}
+	case "paseto":
+		format = pasetoFormat{}
+		for _, keys := range []*keyRing{accessTokenKeys, refreshTokenKeys} {
+			if err := format.check(keys.current()); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported token format %q", tokenFormat)
+	}
+
+	issuer := config.GetString("TOKEN_ISSUER", "jwt-auth-demo")
 	return &maker{
-		accessTokenSecret:    config.GetString("ACCESS_TOKEN_SECRET", "access_secret"),
-		accessTokenDuration:  config.GetDuration("ACCESS_TOKEN_DURATION", time.Minute*5),
-		refreshTokenSecret:   config.GetString("REFRESH_TOKEN_SECRET", "refresh_secret"),
-		refreshTokenDuration: config.GetDuration("REFRESH_TOKEN_DURATION", time.Hour*24*7),
+		format:               format,
+		issuer:               issuer,
+		audience:             config.GetString("TOKEN_AUDIENCE", issuer),
+		leeway:               leeway,
+		accessTokenKeys:      accessTokenKeys,
+		accessTokenDuration:  accessTokenDuration,
+		refreshTokenKeys:     refreshTokenKeys,
+		refreshTokenDuration: refreshTokenDuration,
+	}, nil
+}
+
+// GenerateAccessToken signs claims as an access token, filling in the
+// token id, the issuer and the validity period. An expiry set beforehand is
+// only kept if it is earlier than the default one. Tokens without an
+// audience are issued for the default one.
+func (m *maker) GenerateAccessToken(claims *Claims) (string, error) {
+	if len(claims.Audience) == 0 {
+		claims.Audience = Audience{m.audience}
 	}
+	return m.generate(claims, accessTokenType, m.accessTokenDuration, m.accessTokenKeys)
+}
+
+// GenerateRefreshToken signs claims as a refresh token, filling in the
+// token id, the issuer and the validity period. Refresh tokens are only
+// ever accepted by the issuer itself.
+func (m *maker) GenerateRefreshToken(claims *Claims) (string, error) {
+	claims.Audience = Audience{m.issuer}
+	return m.generate(claims, refreshTokenType, m.refreshTokenDuration, m.refreshTokenKeys)
+}
+
+// VerifyAccessToken verifies an access token and, unless audience is
+// empty, that it has been issued for that audience.
+func (m *maker) VerifyAccessToken(accessToken string, audience string) (*Claims, error) {
+	return m.verify(accessToken, accessTokenType, audience, m.accessTokenKeys)
+}
+
+func (m *maker) VerifyRefreshToken(refreshToken string) (*Claims, error) {
+	return m.verify(refreshToken, refreshTokenType, m.issuer, m.refreshTokenKeys)
 }
 
-func (m *maker) GenerateAccessToken(userId int, email string) (string, error) {
-	return m.generateJwt(jwtgo.MapClaims{
-		"sub":   userId,
-		"email": email,
-	}, m.accessTokenDuration, m.accessTokenSecret)
+func (m *maker) Issuer() string {
+	return m.issuer
 }
 
-func (m *maker) GenerateRefreshToken(userId int) (string, error) {
-	return m.generateJwt(jwtgo.MapClaims{
-		"sub": userId,
-	}, m.refreshTokenDuration, m.refreshTokenSecret)
+func (m *maker) Audience() string {
+	return m.audience
+}
+
+// AccessTokenLifetime is the longest time an access token is accepted for
+// after being issued.
+func (m *maker) AccessTokenLifetime() time.Duration {
+	return m.accessTokenDuration + m.leeway
+}
+
+func (m *maker) PublicKeys() JSONWebKeySet {
+	keys := make([]JSONWebKey, 0)
+	seen := make(map[string]bool)
+	for _, ring := range []*keyRing{m.accessTokenKeys, m.refreshTokenKeys} {
+		for _, key := range ring.publicKeys() {
+			if !seen[key.Kid] {
+				seen[key.Kid] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	return JSONWebKeySet{Keys: keys}
 }
 
-func (m *maker) VerifyRefreshToken(refreshToken string) (jwtgo.MapClaims, error) {
-	return m.verifyJwt(refreshToken, m.refreshTokenSecret)
+// RotateKeys reloads the keys of the access and the refresh tokens and
+// activates the ones configured anew. Either may be left unchanged, but not
+// both. Neither is rotated unless the keys of both load.
+func (m *maker) RotateKeys() error {
+	access, err := m.accessTokenKeys.prepareRotation()
+	if err != nil {
+		return fmt.Errorf("cannot rotate access token keys: %w", err)
+	}
+	refresh, err := m.refreshTokenKeys.prepareRotation()
+	if err != nil {
+		return fmt.Errorf("cannot rotate refresh token keys: %w", err)
+	}
+	if !access.rotated && !refresh.rotated {
+		return fmt.Errorf("cannot rotate keys: %w", errNoNewKey)
+	}
+	for _, next := range []*rotation{access, refresh} {
+		if err := m.format.check(next.keys.active); err != nil {
+			return fmt.Errorf("cannot rotate keys: %w", err)
+		}
+	}
+
+	access.apply()
+	refresh.apply()
+	return nil
 }
 
-func (m *maker) generateJwt(claims jwtgo.MapClaims, exp time.Duration, secret string) (string, error) {
+func (m *maker) generate(claims *Claims, tokenType string, exp time.Duration, keys *keyRing) (string, error) {
 	id, err := gonanoid.New()
 	if err != nil {
 		return "", err
@@ -56,28 +184,72 @@ func (m *maker) generateJwt(claims jwtgo.MapClaims, exp time.Duration, secret st
 
 	now := time.Now()
 
-	claims["id"] = id
-	claims["iat"] = now.Unix()
-	claims["exp"] = now.Add(exp).Unix()
+	claims.Id = id
+	claims.Issuer = m.issuer
+	claims.IssuedAt = now.Unix()
+	claims.NotBefore = now.Unix()
+	if expiresAt := now.Add(exp).Unix(); claims.ExpiresAt == 0 || claims.ExpiresAt > expiresAt {
+		claims.ExpiresAt = expiresAt
+	}
 
-	token := jwtgo.NewWithClaims(jwtgo.SigningMethodHS256, claims)
-	return token.SignedString([]byte(secret))
+	return m.format.sign(claims, tokenType, keys.current())
 }
 
-func (m *maker) verifyJwt(token string, secret string) (jwtgo.MapClaims, error) {
-	t, err := jwtgo.Parse(token, func(t *jwtgo.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwtgo.SigningMethodHMAC); !ok {
+func (m *maker) verify(token string, tokenType string, audience string, keys *keyRing) (*Claims, error) {
+	claims, err := m.format.parse(token, tokenType, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := claims.Validate(time.Now(), m.leeway); err != nil {
+		return nil, err
+	}
+	if claims.Issuer != m.issuer {
+		return nil, errors.New("unexpected token issuer")
+	}
+	if audience != "" && !claims.Audience.Contains(audience) {
+		return nil, errors.New("unexpected token audience")
+	}
+	return claims, nil
+}
+
+// jwtFormat encodes tokens as JSON Web Tokens.
+type jwtFormat struct{}
+
+func (jwtFormat) check(key *signingKey) error {
+	return nil
+}
+
+func (jwtFormat) sign(claims *Claims, tokenType string, key *signingKey) (string, error) {
+	token := jwtgo.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.id
+	token.Header["typ"] = tokenType
+	return token.SignedString(key.signKey)
+}
+
+func (jwtFormat) parse(token string, tokenType string, keys *keyRing) (*Claims, error) {
+	claims := new(Claims)
+	parser := &jwtgo.Parser{SkipClaimsValidation: true}
+	t, err := parser.ParseWithClaims(token, claims, func(t *jwtgo.Token) (interface{}, error) {
+		if typ, _ := t.Header["typ"].(string); typ != tokenType {
+			return nil, errors.New("unexpected token type")
+		}
+		kid, _ := t.Header["kid"].(string)
+		key, ok := keys.lookup(kid)
+		if !ok {
+			return nil, errors.New("unknown signing key")
+		}
+		if t.Method.Alg() != key.method.Alg() {
 			return nil, errors.New("unexpected signing method")
 		}
-		return []byte(secret), nil
+		return key.verifyKey, nil
 	})
 
 	if err != nil {
 		return nil, err
 	}
-
-	if _, ok := t.Claims.(jwtgo.Claims); !ok && !t.Valid {
+	if !t.Valid {
 		return nil, errors.New("token is invalid")
 	}
-	return t.Claims.(jwtgo.MapClaims), err
+	return claims, nil
 }
@@ -0,0 +1,239 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"github.com/evleria/jwt-auth-demo/pkg/jose"
+)
+
+const minRSAKeyBits = 2048
+
+type signingKey struct {
+	id        string
+	method    jwtgo.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+	publicKey *JSONWebKey
+}
+
+func loadSigningKey(algorithm, secret, privateKeyPath string) (*signingKey, error) {
+	method, err := getSigningMethod(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := method.(*jwtgo.SigningMethodHMAC); ok {
+		if secret == "" {
+			return nil, fmt.Errorf("%s requires a secret", algorithm)
+		}
+		return newHMACKey(method, []byte(secret)), nil
+	}
+
+	if privateKeyPath == "" {
+		return nil, fmt.Errorf("%s requires a private key file", algorithm)
+	}
+	block, err := readPEM(privateKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := parsePrivateKey(block)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse private key %s: %w", privateKeyPath, err)
+	}
+	return newSigningKey(method, privateKey)
+}
+
+func loadVerificationKey(algorithm, path string) (*signingKey, error) {
+	method, err := getSigningMethod(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var publicKey crypto.PublicKey
+	if block.Type == "PUBLIC KEY" {
+		publicKey, err = x509.ParsePKIXPublicKey(block.Bytes)
+	} else {
+		var privateKey crypto.Signer
+		privateKey, err = parsePrivateKey(block)
+		if err == nil {
+			publicKey = privateKey.Public()
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse key %s: %w", path, err)
+	}
+	return newVerificationKey(method, publicKey)
+}
+
+// GenerateKey generates a key for the algorithm in the form it is
+// configured in: a random secret for HMAC algorithms, a PEM encoded PKCS #8
+// private key for the others.
+func GenerateKey(algorithm string) ([]byte, error) {
+	method, err := getSigningMethod(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	var privateKey crypto.Signer
+	switch m := method.(type) {
+	case *jwtgo.SigningMethodHMAC:
+		secret := make([]byte, m.Hash.Size())
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		return []byte(base64.RawURLEncoding.EncodeToString(secret)), nil
+	case *jwtgo.SigningMethodRSA, *jwtgo.SigningMethodRSAPSS:
+		privateKey, err = rsa.GenerateKey(rand.Reader, minRSAKeyBits)
+	case *jwtgo.SigningMethodECDSA:
+		var curve elliptic.Curve
+		switch m.CurveBits {
+		case 256:
+			curve = elliptic.P256()
+		case 384:
+			curve = elliptic.P384()
+		default:
+			curve = elliptic.P521()
+		}
+		privateKey, err = ecdsa.GenerateKey(curve, rand.Reader)
+	case *jose.SigningMethodEd25519:
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", m.Alg())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func newHMACKey(method jwtgo.SigningMethod, secret []byte) *signingKey {
+	sum := sha256.Sum256(secret)
+	return &signingKey{
+		id:        base64.RawURLEncoding.EncodeToString(sum[:12]),
+		method:    method,
+		signKey:   secret,
+		verifyKey: secret,
+	}
+}
+
+func newSigningKey(method jwtgo.SigningMethod, privateKey crypto.Signer) (*signingKey, error) {
+	key, err := newVerificationKey(method, privateKey.Public())
+	if err != nil {
+		return nil, err
+	}
+	key.signKey = privateKey
+	return key, nil
+}
+
+func newVerificationKey(method jwtgo.SigningMethod, publicKey crypto.PublicKey) (*signingKey, error) {
+	switch m := method.(type) {
+	case *jwtgo.SigningMethodRSA, *jwtgo.SigningMethodRSAPSS:
+		key, ok := publicKey.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("%s requires an RSA key", method.Alg())
+		}
+		if key.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("%s requires an RSA key of at least %d bits", method.Alg(), minRSAKeyBits)
+		}
+	case *jwtgo.SigningMethodECDSA:
+		key, ok := publicKey.(*ecdsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("%s requires an ECDSA key", method.Alg())
+		}
+		if key.Curve.Params().BitSize != m.CurveBits {
+			return nil, fmt.Errorf("%s requires a P-%d key", method.Alg(), m.CurveBits)
+		}
+	case *jose.SigningMethodEd25519:
+		if _, ok := publicKey.(ed25519.PublicKey); !ok {
+			return nil, fmt.Errorf("%s requires an Ed25519 key", method.Alg())
+		}
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", method.Alg())
+	}
+
+	jwk, err := NewJSONWebKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	id, err := jwk.Thumbprint()
+	if err != nil {
+		return nil, err
+	}
+	jwk.Kid = id
+	jwk.Alg = method.Alg()
+	jwk.Use = "sig"
+
+	return &signingKey{
+		id:        id,
+		method:    method,
+		verifyKey: publicKey,
+		publicKey: &jwk,
+	}, nil
+}
+
+func getSigningMethod(algorithm string) (jwtgo.SigningMethod, error) {
+	method := jwtgo.GetSigningMethod(algorithm)
+	if method == nil || method == jwtgo.SigningMethodNone {
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+	return method, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+	return block, nil
+}
+
+func parsePrivateKey(block *pem.Block) (crypto.Signer, error) {
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported private key type")
+	}
+	return signer, nil
+}
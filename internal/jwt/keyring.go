@@ -0,0 +1,188 @@
+package jwt
+
+import (
+	"errors"
+	"fmt"
+	"github.com/evleria/jwt-auth-demo/internal/config"
+	"sync"
+	"time"
+)
+
+var errNoNewKey = errors.New("no new key configured")
+
+// keyRing holds the active signing key of a token type together with the
+// retired keys that are still accepted while tokens signed by them live.
+type keyRing struct {
+	mu      sync.RWMutex
+	active  *signingKey
+	retired []retiredKey
+	overlap time.Duration
+	load    func() (*keySet, error)
+}
+
+type retiredKey struct {
+	key *signingKey
+	// expiresAt is zero for keys retired through configuration, which are
+	// accepted until they are removed from it.
+	expiresAt time.Time
+}
+
+// keySet is the active key and the retired keys of a token type as they
+// are configured.
+type keySet struct {
+	active  *signingKey
+	retired []retiredKey
+}
+
+// rotation is a key set loaded for a key ring that is not applied yet.
+type rotation struct {
+	ring    *keyRing
+	keys    *keySet
+	rotated bool
+}
+
+func newKeyRingFromConfig(prefix, defaultSecret string, overlap time.Duration) (*keyRing, error) {
+	load := func() (*keySet, error) {
+		return loadKeySetFromConfig(prefix, defaultSecret)
+	}
+
+	keys, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	return &keyRing{
+		active:  keys.active,
+		retired: keys.retired,
+		overlap: overlap,
+		load:    load,
+	}, nil
+}
+
+func loadKeySetFromConfig(prefix, defaultSecret string) (*keySet, error) {
+	algorithm := config.GetString(prefix+"_ALGORITHM", "HS256")
+	active, err := loadSigningKey(
+		algorithm,
+		config.GetString(prefix+"_SECRET", defaultSecret),
+		config.GetString(prefix+"_PRIVATE_KEY", ""),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	retired := make([]retiredKey, 0)
+	for _, secret := range config.GetStrings(prefix+"_RETIRED_SECRETS", nil) {
+		key, err := loadSigningKey(algorithm, secret, "")
+		if err != nil {
+			return nil, fmt.Errorf("cannot load retired secret: %w", err)
+		}
+		retired = append(retired, retiredKey{key: key})
+	}
+	for _, path := range config.GetStrings(prefix+"_RETIRED_KEYS", nil) {
+		key, err := loadVerificationKey(algorithm, path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load retired key: %w", err)
+		}
+		retired = append(retired, retiredKey{key: key})
+	}
+
+	return &keySet{
+		active:  active,
+		retired: retired,
+	}, nil
+}
+
+func (r *keyRing) current() *signingKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.active
+}
+
+func (r *keyRing) lookup(id string) (*signingKey, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if id == "" || id == r.active.id {
+		return r.active, true
+	}
+	now := time.Now()
+	for _, retired := range r.retired {
+		if retired.key.id == id && (retired.expiresAt.IsZero() || now.Before(retired.expiresAt)) {
+			return retired.key, true
+		}
+	}
+	return nil, false
+}
+
+func (r *keyRing) publicKeys() []JSONWebKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	keys := make([]JSONWebKey, 0, len(r.retired)+1)
+	if r.active.publicKey != nil {
+		keys = append(keys, *r.active.publicKey)
+	}
+	now := time.Now()
+	for _, retired := range r.retired {
+		if retired.key.publicKey != nil && (retired.expiresAt.IsZero() || now.Before(retired.expiresAt)) {
+			keys = append(keys, *retired.key.publicKey)
+		}
+	}
+	return keys
+}
+
+// prepareRotation loads the configured keys again, including their
+// algorithm and the retired keys, without applying them to the ring yet.
+func (r *keyRing) prepareRotation() (*rotation, error) {
+	keys, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	known, ok := r.find(keys.active.id)
+	if ok && known.method.Alg() != keys.active.method.Alg() {
+		return nil, fmt.Errorf("key %s is used with %s, it cannot change to %s", known.id, known.method.Alg(), keys.active.method.Alg())
+	}
+	return &rotation{
+		ring:    r,
+		keys:    keys,
+		rotated: !ok,
+	}, nil
+}
+
+// apply makes the loaded key active if it has changed since it was last
+// loaded, and replaces the retired keys of the configuration. The previous
+// key keeps verifying tokens for the lifetime of the tokens it has signed.
+func (p *rotation) apply() {
+	r := p.ring
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	retired := make([]retiredKey, 0, len(r.retired)+len(p.keys.retired)+1)
+	for _, key := range r.retired {
+		if !key.expiresAt.IsZero() && now.Before(key.expiresAt) {
+			retired = append(retired, key)
+		}
+	}
+	if p.rotated {
+		retired = append(retired, retiredKey{key: r.active, expiresAt: now.Add(r.overlap)})
+		r.active = p.keys.active
+	}
+	r.retired = append(retired, p.keys.retired...)
+}
+
+func (r *keyRing) find(id string) (*signingKey, bool) {
+	if id == r.active.id {
+		return r.active, true
+	}
+	for _, retired := range r.retired {
+		if retired.key.id == id {
+			return retired.key, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,95 @@
+package jwt
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRotateKeys(t *testing.T) {
+	t.Setenv("TOKEN_FORMAT", "jwt")
+	t.Setenv("TOKEN_LEEWAY", "30s")
+	t.Setenv("ACCESS_TOKEN_DURATION", "5m")
+	t.Setenv("ACCESS_TOKEN_ALGORITHM", "HS256")
+	t.Setenv("ACCESS_TOKEN_SECRET", "first-access-secret")
+	t.Setenv("ACCESS_TOKEN_RETIRED_SECRETS", "")
+	t.Setenv("REFRESH_TOKEN_ALGORITHM", "HS256")
+	t.Setenv("REFRESH_TOKEN_SECRET", "first-refresh-secret")
+
+	jwtMaker, err := NewMakerFromConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := jwtMaker.(*maker)
+	first := m.accessTokenKeys.current()
+	accessToken, err := m.GenerateAccessToken(&Claims{Subject: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("a refresh key that fails to load rotates nothing", func(t *testing.T) {
+		t.Setenv("ACCESS_TOKEN_SECRET", "second-access-secret")
+		t.Setenv("REFRESH_TOKEN_ALGORITHM", "RS256")
+
+		if err := m.RotateKeys(); err == nil {
+			t.Fatal("got no error")
+		}
+		if m.accessTokenKeys.current() != first {
+			t.Fatal("access token keys have been rotated")
+		}
+	})
+
+	t.Run("the algorithm and the retired secrets are read again", func(t *testing.T) {
+		t.Setenv("ACCESS_TOKEN_ALGORITHM", "HS512")
+		t.Setenv("ACCESS_TOKEN_SECRET", "second-access-secret")
+		t.Setenv("ACCESS_TOKEN_RETIRED_SECRETS", "retired-access-secret")
+
+		if err := m.RotateKeys(); err != nil {
+			t.Fatalf("got error %v, want none", err)
+		}
+		if alg := m.accessTokenKeys.current().method.Alg(); alg != "HS512" {
+			t.Fatalf("got algorithm %s, want HS512", alg)
+		}
+		if _, ok := m.accessTokenKeys.lookup(newHMACKey(first.method, []byte("retired-access-secret")).id); !ok {
+			t.Fatal("retired secret is not accepted")
+		}
+	})
+
+	t.Run("the previous key outlives its tokens by the leeway", func(t *testing.T) {
+		if _, err := m.VerifyAccessToken(accessToken, ""); err != nil {
+			t.Fatalf("got error %v, want none", err)
+		}
+		for _, retired := range m.accessTokenKeys.retired {
+			if retired.key != first {
+				continue
+			}
+			if overlap := time.Until(retired.expiresAt); overlap < 5*time.Minute+29*time.Second {
+				t.Fatalf("previous key expires in %v, want 5m30s", overlap)
+			}
+			return
+		}
+		t.Fatal("previous key has not been retired")
+	})
+
+	t.Run("unchanged keys rotate nothing", func(t *testing.T) {
+		t.Setenv("ACCESS_TOKEN_ALGORITHM", "HS512")
+		t.Setenv("ACCESS_TOKEN_SECRET", "second-access-secret")
+
+		if err := m.RotateKeys(); !errors.Is(err, errNoNewKey) {
+			t.Fatalf("got error %v, want %v", err, errNoNewKey)
+		}
+		if _, ok := m.accessTokenKeys.lookup(newHMACKey(first.method, []byte("retired-access-secret")).id); !ok {
+			t.Fatal("retired secret has been dropped")
+		}
+	})
+
+	t.Run("the algorithm of a key cannot change", func(t *testing.T) {
+		t.Setenv("ACCESS_TOKEN_ALGORITHM", "HS384")
+		t.Setenv("ACCESS_TOKEN_SECRET", "second-access-secret")
+
+		err := m.RotateKeys()
+		if err == nil || errors.Is(err, errNoNewKey) {
+			t.Fatalf("got error %v, want a changed algorithm", err)
+		}
+	})
+}
@@ -0,0 +1,278 @@
+package jwt
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"github.com/evleria/jwt-auth-demo/pkg/jose"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20"
+)
+
+const (
+	pasetoLocalHeader  = "v4.local."
+	pasetoPublicHeader = "v4.public."
+)
+
+// pasetoTimeClaims are the claims PASETO encodes as RFC 3339 strings rather
+// than as numeric dates.
+var pasetoTimeClaims = []string{"exp", "iat", "nbf"}
+
+// pasetoFormat encodes tokens as PASETO v4 tokens: v4.local for tokens
+// whose key ring holds secrets and v4.public for Ed25519 key rings. The key
+// id travels in the footer and the token type is bound to the token as the
+// implicit assertion. Unlike with JWT, the purpose and the algorithm follow
+// from the key alone.
+type pasetoFormat struct{}
+
+func (pasetoFormat) check(key *signingKey) error {
+	_, err := getPasetoPurpose(key)
+	return err
+}
+
+func (pasetoFormat) sign(claims *Claims, tokenType string, key *signingKey) (string, error) {
+	purpose, err := getPasetoPurpose(key)
+	if err != nil {
+		return "", err
+	}
+
+	message, err := marshalPasetoClaims(claims)
+	if err != nil {
+		return "", err
+	}
+	footer, err := json.Marshal(pasetoFooter{Kid: key.id})
+	if err != nil {
+		return "", err
+	}
+
+	var body []byte
+	if purpose == pasetoLocalHeader {
+		body, err = pasetoEncrypt(getPasetoLocalKey(key), message, footer, []byte(tokenType))
+	} else {
+		body = pasetoSign(key.signKey.(ed25519.PrivateKey), message, footer, []byte(tokenType))
+	}
+	if err != nil {
+		return "", err
+	}
+	return purpose + base64.RawURLEncoding.EncodeToString(body) + "." + base64.RawURLEncoding.EncodeToString(footer), nil
+}
+
+func (pasetoFormat) parse(token string, tokenType string, keys *keyRing) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 4 {
+		return nil, errors.New("malformed token")
+	}
+	purpose := parts[0] + "." + parts[1] + "."
+
+	body, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed token")
+	}
+	footer, err := base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil {
+		return nil, errors.New("malformed token")
+	}
+	var f pasetoFooter
+	if err := json.Unmarshal(footer, &f); err != nil {
+		return nil, errors.New("malformed token footer")
+	}
+
+	key, ok := keys.lookup(f.Kid)
+	if !ok {
+		return nil, errors.New("unknown signing key")
+	}
+	if keyPurpose, err := getPasetoPurpose(key); err != nil || keyPurpose != purpose {
+		return nil, errors.New("unexpected token purpose")
+	}
+
+	var message []byte
+	if purpose == pasetoLocalHeader {
+		message, err = pasetoDecrypt(getPasetoLocalKey(key), body, footer, []byte(tokenType))
+	} else {
+		message, err = pasetoVerify(key.verifyKey.(ed25519.PublicKey), body, footer, []byte(tokenType))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalPasetoClaims(message)
+}
+
+type pasetoFooter struct {
+	Kid string `json:"kid"`
+}
+
+func getPasetoPurpose(key *signingKey) (string, error) {
+	switch key.method.(type) {
+	case *jwtgo.SigningMethodHMAC:
+		return pasetoLocalHeader, nil
+	case *jose.SigningMethodEd25519:
+		return pasetoPublicHeader, nil
+	default:
+		return "", fmt.Errorf("PASETO requires a secret or an Ed25519 key, not %s", key.method.Alg())
+	}
+}
+
+// getPasetoLocalKey derives the 256-bit v4.local key from a secret of any
+// length.
+func getPasetoLocalKey(key *signingKey) []byte {
+	sum := blake2b.Sum256(key.signKey.([]byte))
+	return sum[:]
+}
+
+func pasetoEncrypt(key, message, footer, implicit []byte) ([]byte, error) {
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return pasetoEncryptWithNonce(key, nonce, message, footer, implicit)
+}
+
+func pasetoEncryptWithNonce(key, nonce, message, footer, implicit []byte) ([]byte, error) {
+	encryptionKey, counterNonce, authKey := pasetoSplitKey(key, nonce)
+	cipher, err := chacha20.NewUnauthenticatedCipher(encryptionKey, counterNonce)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext := make([]byte, len(message))
+	cipher.XORKeyStream(ciphertext, message)
+
+	tag := pasetoMAC(authKey, pae([]byte(pasetoLocalHeader), nonce, ciphertext, footer, implicit))
+	return bytes.Join([][]byte{nonce, ciphertext, tag}, nil), nil
+}
+
+func pasetoDecrypt(key, body, footer, implicit []byte) ([]byte, error) {
+	if len(body) < 64 {
+		return nil, errors.New("malformed token")
+	}
+	nonce, ciphertext, tag := body[:32], body[32:len(body)-32], body[len(body)-32:]
+
+	encryptionKey, counterNonce, authKey := pasetoSplitKey(key, nonce)
+	expected := pasetoMAC(authKey, pae([]byte(pasetoLocalHeader), nonce, ciphertext, footer, implicit))
+	if !hmac.Equal(tag, expected) {
+		return nil, errors.New("token is invalid")
+	}
+
+	cipher, err := chacha20.NewUnauthenticatedCipher(encryptionKey, counterNonce)
+	if err != nil {
+		return nil, err
+	}
+	message := make([]byte, len(ciphertext))
+	cipher.XORKeyStream(message, ciphertext)
+	return message, nil
+}
+
+// pasetoSplitKey derives the encryption key, the XChaCha20 nonce and the
+// authentication key of a single v4.local token.
+func pasetoSplitKey(key, nonce []byte) ([]byte, []byte, []byte) {
+	encryption := blake2bMAC(key, 56, []byte("paseto-encryption-key"), nonce)
+	authKey := blake2bMAC(key, 32, []byte("paseto-auth-key-for-aead"), nonce)
+	return encryption[:32], encryption[32:], authKey
+}
+
+func pasetoMAC(key, message []byte) []byte {
+	return blake2bMAC(key, 32, message)
+}
+
+func blake2bMAC(key []byte, size int, message ...[]byte) []byte {
+	h, _ := blake2b.New(size, key)
+	for _, m := range message {
+		h.Write(m)
+	}
+	return h.Sum(nil)
+}
+
+func pasetoSign(privateKey ed25519.PrivateKey, message, footer, implicit []byte) []byte {
+	signature := ed25519.Sign(privateKey, pae([]byte(pasetoPublicHeader), message, footer, implicit))
+	return bytes.Join([][]byte{message, signature}, nil)
+}
+
+func pasetoVerify(publicKey ed25519.PublicKey, body, footer, implicit []byte) ([]byte, error) {
+	if len(body) < ed25519.SignatureSize {
+		return nil, errors.New("malformed token")
+	}
+	message, signature := body[:len(body)-ed25519.SignatureSize], body[len(body)-ed25519.SignatureSize:]
+	if !ed25519.Verify(publicKey, pae([]byte(pasetoPublicHeader), message, footer, implicit), signature) {
+		return nil, errors.New("token is invalid")
+	}
+	return message, nil
+}
+
+// pae is the pre-authentication encoding of PASETO.
+func pae(pieces ...[]byte) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint64(len(pieces)))
+	for _, piece := range pieces {
+		binary.Write(&buf, binary.LittleEndian, uint64(len(piece)))
+		buf.Write(piece)
+	}
+	return buf.Bytes()
+}
+
+func marshalPasetoClaims(claims *Claims) ([]byte, error) {
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := decodeClaimsMap(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range pasetoTimeClaims {
+		if value, ok := payload[name].(json.Number); ok {
+			seconds, err := value.Int64()
+			if err != nil {
+				return nil, err
+			}
+			payload[name] = time.Unix(seconds, 0).UTC().Format(time.RFC3339)
+		}
+	}
+	return json.Marshal(payload)
+}
+
+func unmarshalPasetoClaims(data []byte) (*Claims, error) {
+	payload, err := decodeClaimsMap(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range pasetoTimeClaims {
+		if value, ok := payload[name].(string); ok {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, fmt.Errorf("%s is not a valid date", name)
+			}
+			payload[name] = t.Unix()
+		}
+	}
+
+	data, err = json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	claims := new(Claims)
+	if err := json.Unmarshal(data, claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// decodeClaimsMap decodes claims keeping numbers as they are, so that
+// numeric dates survive the round trip exactly.
+func decodeClaimsMap(data []byte) (map[string]interface{}, error) {
+	payload := make(map[string]interface{})
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
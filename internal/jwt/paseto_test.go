@@ -0,0 +1,194 @@
+package jwt
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// The vectors are the v4 ones of github.com/paseto-standard/test-vectors.
+const (
+	pasetoVectorLocalKey    = "707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f"
+	pasetoVectorSecretKey   = "b4cbfb43df4ce210727d953e4a713307fa19bb7d9f85041438d9e11b942a37741eb9dbbbbc047c03fd70604e0071f0987e16b28b757225c11f00415d0e20b1a2"
+	pasetoVectorPublicKey   = "1eb9dbbbbc047c03fd70604e0071f0987e16b28b757225c11f00415d0e20b1a2"
+	pasetoVectorZeroNonce   = "0000000000000000000000000000000000000000000000000000000000000000"
+	pasetoVectorNonce       = "df654812bac492663825520ba2f6e67cf5ca5bdc13d4e7507a98cc4c2fcc3ad8"
+	pasetoVectorFooter      = `{"kid":"zVhMiPBP9fRf2snEcT7gFTioeA9COcNy9DfgL1W60haN"}`
+	pasetoVectorSecretData  = `{"data":"this is a secret message","exp":"2022-01-01T00:00:00+00:00"}`
+	pasetoVectorHiddenData  = `{"data":"this is a hidden message","exp":"2022-01-01T00:00:00+00:00"}`
+	pasetoVectorSignedData  = `{"data":"this is a signed message","exp":"2022-01-01T00:00:00+00:00"}`
+	pasetoVectorFooterToken = ".eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9"
+)
+
+type pasetoVector struct {
+	name     string
+	nonce    string
+	payload  string
+	footer   string
+	implicit string
+	token    string
+}
+
+var pasetoLocalVectors = []pasetoVector{
+	{
+		name:    "4-E-1",
+		nonce:   pasetoVectorZeroNonce,
+		payload: pasetoVectorSecretData,
+		token:   "v4.local.AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAQAr68PS4AXe7If_ZgesdkUMvSwscFlAl1pk5HC0e8kApeaqMfGo_7OpBnwJOAbY9V7WU6abu74MmcUE8YWAiaArVI8XJ5hOb_4v9RmDkneN0S92dx0OW4pgy7omxgf3S8c3LlQg",
+	},
+	{
+		name:    "4-E-2",
+		nonce:   pasetoVectorZeroNonce,
+		payload: pasetoVectorHiddenData,
+		token:   "v4.local.AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAQAr68PS4AXe7If_ZgesdkUMvS2csCgglvpk5HC0e8kApeaqMfGo_7OpBnwJOAbY9V7WU6abu74MmcUE8YWAiaArVI8XIemu9chy3WVKvRBfg6t8wwYHK0ArLxxfZP73W_vfwt5A",
+	},
+	{
+		name:    "4-E-3",
+		nonce:   pasetoVectorNonce,
+		payload: pasetoVectorSecretData,
+		token:   "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WkwMsYXw6FSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t6-tyebyWG6Ov7kKvBdkrrAJ837lKP3iDag2hzUPHuMKA",
+	},
+	{
+		name:    "4-E-4",
+		nonce:   pasetoVectorNonce,
+		payload: pasetoVectorHiddenData,
+		token:   "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WiA8rd3wgFSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t4gt6TiLm55vIH8c_lGxxZpE3AWlH4WTR0v45nsWoU3gQ",
+	},
+	{
+		name:    "4-E-5",
+		nonce:   pasetoVectorNonce,
+		payload: pasetoVectorSecretData,
+		footer:  pasetoVectorFooter,
+		token:   "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WkwMsYXw6FSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t4x-RMNXtQNbz7FvFZ_G-lFpk5RG3EOrwDL6CgDqcerSQ" + pasetoVectorFooterToken,
+	},
+	{
+		name:    "4-E-6",
+		nonce:   pasetoVectorNonce,
+		payload: pasetoVectorHiddenData,
+		footer:  pasetoVectorFooter,
+		token:   "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WiA8rd3wgFSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t6pWSA5HX2wjb3P-xLQg5K5feUCX4P2fpVK3ZLWFbMSxQ" + pasetoVectorFooterToken,
+	},
+	{
+		name:     "4-E-7",
+		nonce:    pasetoVectorNonce,
+		payload:  pasetoVectorSecretData,
+		footer:   pasetoVectorFooter,
+		implicit: `{"test-vector":"4-E-7"}`,
+		token:    "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WkwMsYXw6FSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t40KCCWLA7GYL9KFHzKlwY9_RnIfRrMQpueydLEAZGGcA" + pasetoVectorFooterToken,
+	},
+	{
+		name:     "4-E-8",
+		nonce:    pasetoVectorNonce,
+		payload:  pasetoVectorHiddenData,
+		footer:   pasetoVectorFooter,
+		implicit: `{"test-vector":"4-E-8"}`,
+		token:    "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WiA8rd3wgFSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t5uvqQbMGlLLNYBc7A6_x7oqnpUK5WLvj24eE4DVPDZjw" + pasetoVectorFooterToken,
+	},
+}
+
+var pasetoPublicVectors = []pasetoVector{
+	{
+		name:    "4-S-1",
+		payload: pasetoVectorSignedData,
+		token:   "v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9bg_XBBzds8lTZShVlwwKSgeKpLT3yukTw6JUz3W4h_ExsQV-P0V54zemZDcAxFaSeef1QlXEFtkqxT1ciiQEDA",
+	},
+	{
+		name:    "4-S-2",
+		payload: pasetoVectorSignedData,
+		footer:  pasetoVectorFooter,
+		token:   "v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9v3Jt8mx_TdM2ceTGoqwrh4yDFn0XsHvvV_D0DtwQxVrJEBMl0F2caAdgnpKlt4p7xBnx1HcO-SPo8FPp214HDw" + pasetoVectorFooterToken,
+	},
+	{
+		name:     "4-S-3",
+		payload:  pasetoVectorSignedData,
+		footer:   pasetoVectorFooter,
+		implicit: `{"test-vector":"4-S-3"}`,
+		token:    "v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9NPWciuD3d0o5eXJXG5pJy-DiVEoyPYWs1YSTwWHNJq6DZD3je5gf-0M4JR9ipdUSJbIovzmBECeaWmaqcaP0DQ" + pasetoVectorFooterToken,
+	},
+}
+
+func TestPasetoLocalVectors(t *testing.T) {
+	key := decodeHex(t, pasetoVectorLocalKey)
+	for _, v := range pasetoLocalVectors {
+		t.Run(v.name, func(t *testing.T) {
+			body, err := pasetoEncryptWithNonce(key, decodeHex(t, v.nonce), []byte(v.payload), []byte(v.footer), []byte(v.implicit))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token := encodePasetoVector(pasetoLocalHeader, body, v.footer); token != v.token {
+				t.Fatalf("got token %s, want %s", token, v.token)
+			}
+
+			message, err := pasetoDecrypt(key, decodePasetoVector(t, pasetoLocalHeader, v.token), []byte(v.footer), []byte(v.implicit))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(message) != v.payload {
+				t.Fatalf("got payload %s, want %s", message, v.payload)
+			}
+
+			_, err = pasetoDecrypt(key, decodePasetoVector(t, pasetoLocalHeader, v.token), []byte(v.footer), []byte("tampered"))
+			if err == nil {
+				t.Fatal("token decrypted with a different implicit assertion")
+			}
+		})
+	}
+}
+
+func TestPasetoPublicVectors(t *testing.T) {
+	privateKey := ed25519.PrivateKey(decodeHex(t, pasetoVectorSecretKey))
+	publicKey := ed25519.PublicKey(decodeHex(t, pasetoVectorPublicKey))
+	if !bytes.Equal(privateKey.Public().(ed25519.PublicKey), publicKey) {
+		t.Fatal("secret key does not match public key")
+	}
+
+	for _, v := range pasetoPublicVectors {
+		t.Run(v.name, func(t *testing.T) {
+			body := pasetoSign(privateKey, []byte(v.payload), []byte(v.footer), []byte(v.implicit))
+			if token := encodePasetoVector(pasetoPublicHeader, body, v.footer); token != v.token {
+				t.Fatalf("got token %s, want %s", token, v.token)
+			}
+
+			message, err := pasetoVerify(publicKey, decodePasetoVector(t, pasetoPublicHeader, v.token), []byte(v.footer), []byte(v.implicit))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(message) != v.payload {
+				t.Fatalf("got payload %s, want %s", message, v.payload)
+			}
+
+			_, err = pasetoVerify(publicKey, decodePasetoVector(t, pasetoPublicHeader, v.token), []byte(v.footer), []byte("tampered"))
+			if err == nil {
+				t.Fatal("token verified with a different implicit assertion")
+			}
+		})
+	}
+}
+
+func encodePasetoVector(header string, body []byte, footer string) string {
+	token := header + base64.RawURLEncoding.EncodeToString(body)
+	if footer != "" {
+		token += "." + base64.RawURLEncoding.EncodeToString([]byte(footer))
+	}
+	return token
+}
+
+func decodePasetoVector(t *testing.T, header, token string) []byte {
+	parts := strings.Split(strings.TrimPrefix(token, header), ".")
+	body, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func decodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
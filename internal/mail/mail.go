@@ -0,0 +1,129 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/evleria/jwt-auth-demo/internal/config"
+	gonanoid "github.com/matoous/go-nanoid/v2"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers mail to users.
+type Sender interface {
+	Send(message *Message) error
+}
+
+// NewSenderFromConfig makes the sender chosen by MAIL_SENDER: log, which
+// prints messages to the log, file, which writes them to MAIL_FILE_DIR, or
+// smtp, which sends them through SMTP_HOST.
+func NewSenderFromConfig() (Sender, error) {
+	from := config.GetString("MAIL_FROM", "no-reply@localhost")
+
+	switch sender := config.GetString("MAIL_SENDER", "log"); sender {
+	case "log":
+		return NewLogSender(), nil
+	case "file":
+		return NewFileSender(config.GetString("MAIL_FILE_DIR", "./mail"), from), nil
+	case "smtp":
+		host := config.GetString("SMTP_HOST", "")
+		if host == "" {
+			return nil, fmt.Errorf("smtp mail sender requires SMTP_HOST")
+		}
+		return NewSMTPSender(
+			net.JoinHostPort(host, strconv.Itoa(config.GetInt("SMTP_PORT", 587))),
+			config.GetString("SMTP_USERNAME", ""),
+			config.GetString("SMTP_PASSWORD", ""),
+			from,
+		), nil
+	default:
+		return nil, fmt.Errorf("unsupported mail sender %q", sender)
+	}
+}
+
+type logSender struct{}
+
+// NewLogSender prints messages to the log instead of sending them. It is
+// meant for local runs only, as the log gets the secrets mail carries.
+func NewLogSender() *logSender {
+	return &logSender{}
+}
+
+func (s *logSender) Send(message *Message) error {
+	log.Printf("mail to %s: %s\n%s", message.To, message.Subject, message.Body)
+	return nil
+}
+
+type fileSender struct {
+	dir  string
+	from string
+}
+
+// NewFileSender writes every message to a file of its own in the directory
+// instead of sending it.
+func NewFileSender(dir, from string) *fileSender {
+	return &fileSender{
+		dir:  dir,
+		from: from,
+	}
+}
+
+func (s *fileSender) Send(message *Message) error {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+	id, err := gonanoid.New()
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102T150405"), id)
+	return os.WriteFile(filepath.Join(s.dir, name), format(s.from, message), 0600)
+}
+
+type smtpSender struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+// NewSMTPSender sends messages through an SMTP server, authenticating with
+// PLAIN if a username is given.
+func NewSMTPSender(addr, username, password, from string) *smtpSender {
+	var auth smtp.Auth
+	if username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &smtpSender{
+		addr: addr,
+		auth: auth,
+		from: from,
+	}
+}
+
+func (s *smtpSender) Send(message *Message) error {
+	return smtp.SendMail(s.addr, s.auth, s.from, []string{message.To}, format(s.from, message))
+}
+
+func format(from string, message *Message) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", message.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", message.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(message.Body)
+	return b.Bytes()
+}
@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"context"
+	"github.com/jackc/pgx/v4"
+)
+
+type ClientRepository interface {
+	GetClientById(id string) (*Client, error)
+}
+
+type Client struct {
+	Id         string   `db:"id"`
+	Name       string   `db:"name"`
+	SecretHash *string  `db:"secret_hash"`
+	Audiences  []string `db:"audiences"`
+	// SessionIdleTimeout and SessionMaxLifetime override the session limits
+	// for the client, in seconds.
+	SessionIdleTimeout *int `db:"session_idle_timeout"`
+	SessionMaxLifetime *int `db:"session_max_lifetime"`
+}
+
+// IsConfidential tells whether the client can keep a secret. Public
+// clients only identify themselves by their id.
+func (c *Client) IsConfidential() bool {
+	return c.SecretHash != nil
+}
+
+type clientRepository struct {
+	db *pgx.Conn
+}
+
+func NewClientRepository(db *pgx.Conn) ClientRepository {
+	return &clientRepository{
+		db: db,
+	}
+}
+
+func (r *clientRepository) GetClientById(id string) (*Client, error) {
+	client := new(Client)
+	row := r.db.QueryRow(context.TODO(), "SELECT id, name, secret_hash, audiences, session_idle_timeout, session_max_lifetime FROM clients WHERE id = $1", id)
+	err := row.Scan(&client.Id, &client.Name, &client.SecretHash, &client.Audiences, &client.SessionIdleTimeout, &client.SessionMaxLifetime)
+	return client, err
+}
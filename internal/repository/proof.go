@@ -0,0 +1,34 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+// ProofRepository remembers the ids of used DPoP proofs, so that every proof
+// is accepted only once while it is fresh.
+type ProofRepository interface {
+	Use(proofId string, ttl time.Duration) (bool, error)
+}
+
+type proofRepository struct {
+	redis *redis.Client
+}
+
+func NewProofRepository(redis *redis.Client) ProofRepository {
+	return &proofRepository{
+		redis: redis,
+	}
+}
+
+// Use records the proof id and tells whether it has not been used before.
+func (r *proofRepository) Use(proofId string, ttl time.Duration) (bool, error) {
+	key := getProofKey(proofId)
+	return r.redis.SetNX(context.TODO(), key, 1, ttl).Result()
+}
+
+func getProofKey(proofId string) string {
+	return fmt.Sprintf("dpop::proof::%s", proofId)
+}
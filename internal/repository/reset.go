@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"strconv"
+	"time"
+)
+
+// PasswordResetRepository stores the hashes of password reset tokens. A user
+// has one reset token at most: a new one replaces the previous one, and a
+// token can be used only once.
+type PasswordResetRepository interface {
+	CreateResetToken(userId int, tokenHash string, ttl time.Duration) error
+	UseResetToken(tokenHash string) (int, bool, error)
+}
+
+type passwordResetRepository struct {
+	redis *redis.Client
+}
+
+func NewPasswordResetRepository(redis *redis.Client) PasswordResetRepository {
+	return &passwordResetRepository{
+		redis: redis,
+	}
+}
+
+func (r *passwordResetRepository) CreateResetToken(userId int, tokenHash string, ttl time.Duration) error {
+	ctx := context.TODO()
+	userKey := getUserResetTokenKey(userId)
+	previous, err := r.redis.Get(ctx, userKey).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+
+	_, err = r.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if previous != "" {
+			pipe.Del(ctx, getResetTokenKey(previous))
+		}
+		pipe.Set(ctx, getResetTokenKey(tokenHash), userId, ttl)
+		pipe.Set(ctx, userKey, tokenHash, ttl)
+		return nil
+	})
+	return err
+}
+
+// UseResetToken deletes the token and returns the id of its user, or false
+// if the token has expired or has been used already.
+func (r *passwordResetRepository) UseResetToken(tokenHash string) (int, bool, error) {
+	ctx := context.TODO()
+	key := getResetTokenKey(tokenHash)
+
+	var get *redis.StringCmd
+	_, err := r.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.Get(ctx, key)
+		pipe.Del(ctx, key)
+		return nil
+	})
+	if errors.Is(err, redis.Nil) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	userId, err := strconv.Atoi(get.Val())
+	if err != nil {
+		return 0, false, err
+	}
+	r.redis.Del(ctx, getUserResetTokenKey(userId))
+	return userId, true, nil
+}
+
+func getResetTokenKey(tokenHash string) string {
+	return fmt.Sprintf("password_reset::token::%s", tokenHash)
+}
+
+func getUserResetTokenKey(userId int) string {
+	return fmt.Sprintf("password_reset::user::%d", userId)
+}
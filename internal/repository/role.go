@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v4"
+)
+
+var (
+	ErrRoleNotFound = errors.New("role not found")
+	ErrRoleExists   = errors.New("role already exists")
+)
+
+// RoleRepository stores roles, the permissions they grant and the roles
+// assigned to users.
+type RoleRepository interface {
+	GetRoles() ([]*Role, error)
+	CreateRole(name string, permissions []string) error
+	GetUserRoles(userId int) ([]string, error)
+	GetUserPermissions(userId int) ([]string, error)
+	AssignRole(userId int, role string) error
+	UnassignRole(userId int, role string) error
+}
+
+type Role struct {
+	Id          int      `db:"id"`
+	Name        string   `db:"name"`
+	Permissions []string `db:"permissions"`
+}
+
+type roleRepository struct {
+	db *pgx.Conn
+}
+
+func NewRoleRepository(db *pgx.Conn) RoleRepository {
+	return &roleRepository{
+		db: db,
+	}
+}
+
+func (r *roleRepository) GetRoles() ([]*Role, error) {
+	rows, err := r.db.Query(context.TODO(), `SELECT r.id, r.name, COALESCE(array_agg(p.name ORDER BY p.name) FILTER (WHERE p.name IS NOT NULL), '{}')
+		FROM roles r
+		LEFT JOIN role_permissions rp ON rp.role_id = r.id
+		LEFT JOIN permissions p ON p.id = rp.permission_id
+		GROUP BY r.id ORDER BY r.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := make([]*Role, 0)
+	for rows.Next() {
+		role := new(Role)
+		err = rows.Scan(&role.Id, &role.Name, &role.Permissions)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// CreateRole creates a role granting the permissions, creating the
+// permissions that do not exist yet.
+func (r *roleRepository) CreateRole(name string, permissions []string) error {
+	tx, err := r.db.Begin(context.TODO())
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.TODO())
+
+	var roleId int
+	err = tx.QueryRow(context.TODO(), "INSERT INTO roles (name) VALUES ($1) ON CONFLICT DO NOTHING RETURNING id", name).Scan(&roleId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrRoleExists
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(permissions) > 0 {
+		_, err = tx.Exec(context.TODO(), "INSERT INTO permissions (name) SELECT unnest($1::VARCHAR[]) ON CONFLICT DO NOTHING", permissions)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(context.TODO(), "INSERT INTO role_permissions (role_id, permission_id) SELECT $1, id FROM permissions WHERE name = ANY($2)",
+			roleId, permissions)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(context.TODO())
+}
+
+func (r *roleRepository) GetUserRoles(userId int) ([]string, error) {
+	return r.queryNames(`SELECT r.name FROM user_roles ur
+		JOIN roles r ON r.id = ur.role_id
+		WHERE ur.user_id = $1 ORDER BY r.name`, userId)
+}
+
+func (r *roleRepository) GetUserPermissions(userId int) ([]string, error) {
+	return r.queryNames(`SELECT DISTINCT p.name FROM user_roles ur
+		JOIN role_permissions rp ON rp.role_id = ur.role_id
+		JOIN permissions p ON p.id = rp.permission_id
+		WHERE ur.user_id = $1 ORDER BY p.name`, userId)
+}
+
+func (r *roleRepository) AssignRole(userId int, role string) error {
+	roleId, err := r.getRoleId(role)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.Exec(context.TODO(), "INSERT INTO user_roles (user_id, role_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", userId, roleId)
+	return err
+}
+
+func (r *roleRepository) UnassignRole(userId int, role string) error {
+	roleId, err := r.getRoleId(role)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.Exec(context.TODO(), "DELETE FROM user_roles WHERE user_id = $1 AND role_id = $2", userId, roleId)
+	return err
+}
+
+func (r *roleRepository) getRoleId(role string) (int, error) {
+	var roleId int
+	err := r.db.QueryRow(context.TODO(), "SELECT id FROM roles WHERE name = $1", role).Scan(&roleId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrRoleNotFound
+	}
+	return roleId, err
+}
+
+func (r *roleRepository) queryNames(sql string, args ...interface{}) ([]string, error) {
+	rows, err := r.db.Query(context.TODO(), sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make([]string, 0)
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
@@ -0,0 +1,228 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"strconv"
+	"time"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionRepository stores sessions, i.e. the families of refresh tokens
+// descending from a single login. Only the latest token of a family is
+// accepted.
+type SessionRepository interface {
+	CreateSession(session *Session, ttl time.Duration) error
+	GetSession(sessionId string) (*Session, error)
+	SessionExists(sessionId string) (bool, error)
+	GetUserSessions(userId int) ([]*Session, error)
+	RotateSessionToken(sessionId, tokenId, nextTokenId string, ttl time.Duration) (bool, error)
+	DeleteSession(sessionId string) error
+	DeleteUserSessions(userId int) error
+}
+
+type Session struct {
+	Id       string
+	UserId   int
+	ClientId string
+	Device   string
+	Scope    string
+	// JwkThumbprint binds the refresh tokens of the session to a DPoP key.
+	JwkThumbprint string
+	// CertificateThumbprint binds them to a TLS client certificate.
+	CertificateThumbprint string
+	TokenId               string
+	CreatedAt             time.Time
+	LastUsedAt            time.Time
+	// ExpiresAt is when the session ends no matter how active it is.
+	ExpiresAt time.Time
+}
+
+type sessionRepository struct {
+	redis *redis.Client
+}
+
+func NewSessionRepository(redis *redis.Client) SessionRepository {
+	return &sessionRepository{
+		redis: redis,
+	}
+}
+
+// rotateTokenScript replaces the current token of a session only if the
+// presented token is still the current one: 1 on success, 0 when an older
+// token is presented and -1 when the session does not exist.
+var rotateTokenScript = redis.NewScript(`
+local current = redis.call('HGET', KEYS[1], 'token_id')
+if not current then
+	return -1
+end
+if current ~= ARGV[1] then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'token_id', ARGV[2], 'last_used_at', ARGV[4])
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+local userKey = 'sessions::' .. redis.call('HGET', KEYS[1], 'user_id')
+if redis.call('PTTL', userKey) < tonumber(ARGV[3]) then
+	redis.call('PEXPIRE', userKey, ARGV[3])
+end
+return 1
+`)
+
+// extendTTLScript sets the TTL of a key unless it already lives longer.
+var extendTTLScript = redis.NewScript(`
+if redis.call('PTTL', KEYS[1]) < tonumber(ARGV[1]) then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return 1
+`)
+
+func (r *sessionRepository) CreateSession(session *Session, ttl time.Duration) error {
+	key := getSessionKey(session.Id)
+	userKey := getUserSessionsKey(session.UserId)
+	_, err := r.redis.TxPipelined(context.TODO(), func(pipe redis.Pipeliner) error {
+		pipe.HSet(context.TODO(), key,
+			"user_id", session.UserId,
+			"client_id", session.ClientId,
+			"device", session.Device,
+			"scope", session.Scope,
+			"jkt", session.JwkThumbprint,
+			"x5t", session.CertificateThumbprint,
+			"token_id", session.TokenId,
+			"created_at", session.CreatedAt.Unix(),
+			"last_used_at", session.LastUsedAt.Unix(),
+			"expires_at", session.ExpiresAt.Unix(),
+		)
+		pipe.Expire(context.TODO(), key, ttl)
+		pipe.SAdd(context.TODO(), userKey, session.Id)
+		extendTTLScript.Eval(context.TODO(), pipe, []string{userKey}, ttl.Milliseconds())
+		return nil
+	})
+	return err
+}
+
+func (r *sessionRepository) GetSession(sessionId string) (*Session, error) {
+	key := getSessionKey(sessionId)
+	values, err := r.redis.HGetAll(context.TODO(), key).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, ErrSessionNotFound
+	}
+
+	userId, err := strconv.Atoi(values["user_id"])
+	if err != nil {
+		return nil, err
+	}
+	createdAt, err := strconv.ParseInt(values["created_at"], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	lastUsedAt, err := strconv.ParseInt(values["last_used_at"], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	expiresAt, err := strconv.ParseInt(values["expires_at"], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Session{
+		Id:                    sessionId,
+		UserId:                userId,
+		ClientId:              values["client_id"],
+		Device:                values["device"],
+		Scope:                 values["scope"],
+		JwkThumbprint:         values["jkt"],
+		CertificateThumbprint: values["x5t"],
+		TokenId:               values["token_id"],
+		CreatedAt:             time.Unix(createdAt, 0),
+		LastUsedAt:            time.Unix(lastUsedAt, 0),
+		ExpiresAt:             time.Unix(expiresAt, 0),
+	}, nil
+}
+
+func (r *sessionRepository) SessionExists(sessionId string) (bool, error) {
+	key := getSessionKey(sessionId)
+	n, err := r.redis.Exists(context.TODO(), key).Result()
+	return n > 0, err
+}
+
+func (r *sessionRepository) GetUserSessions(userId int) ([]*Session, error) {
+	userKey := getUserSessionsKey(userId)
+	ids, err := r.redis.SMembers(context.TODO(), userKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]*Session, 0, len(ids))
+	for _, id := range ids {
+		session, err := r.GetSession(id)
+		if errors.Is(err, ErrSessionNotFound) {
+			r.redis.SRem(context.TODO(), userKey, id)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+func (r *sessionRepository) RotateSessionToken(sessionId, tokenId, nextTokenId string, ttl time.Duration) (bool, error) {
+	key := getSessionKey(sessionId)
+	result, err := rotateTokenScript.Run(context.TODO(), r.redis, []string{key},
+		tokenId, nextTokenId, strconv.FormatInt(ttl.Milliseconds(), 10), time.Now().Unix()).Int()
+	if err != nil {
+		return false, err
+	}
+	if result < 0 {
+		return false, ErrSessionNotFound
+	}
+	return result == 1, nil
+}
+
+func (r *sessionRepository) DeleteSession(sessionId string) error {
+	key := getSessionKey(sessionId)
+	userId, err := r.redis.HGet(context.TODO(), key, "user_id").Int()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil
+		}
+		return err
+	}
+
+	_, err = r.redis.TxPipelined(context.TODO(), func(pipe redis.Pipeliner) error {
+		pipe.Del(context.TODO(), key)
+		pipe.SRem(context.TODO(), getUserSessionsKey(userId), sessionId)
+		return nil
+	})
+	return err
+}
+
+func (r *sessionRepository) DeleteUserSessions(userId int) error {
+	userKey := getUserSessionsKey(userId)
+	ids, err := r.redis.SMembers(context.TODO(), userKey).Result()
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(ids)+1)
+	for _, id := range ids {
+		keys = append(keys, getSessionKey(id))
+	}
+	keys = append(keys, userKey)
+	return r.redis.Del(context.TODO(), keys...).Err()
+}
+
+func getSessionKey(sessionId string) string {
+	return fmt.Sprintf("session::%s", sessionId)
+}
+
+func getUserSessionsKey(userId int) string {
+	return fmt.Sprintf("sessions::%d", userId)
+}
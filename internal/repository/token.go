@@ -9,9 +9,14 @@ import (
 	"time"
 )
 
+// Token stores token revocations: single tokens by their id, and every token
+// of a user issued before a cutoff. Entries expire together with the tokens
+// they revoke.
 type Token interface {
-	Blacklist(userId int, t time.Time, ttl time.Duration) error
-	IsBlacklisted(userId int) (time.Time, bool, error)
+	Revoke(tokenId string, ttl time.Duration) error
+	IsRevoked(tokenId string) (bool, error)
+	RevokeUserTokens(userId int, before time.Time, ttl time.Duration) error
+	GetUserRevocation(userId int) (time.Time, bool, error)
 }
 
 type token struct {
@@ -24,13 +29,27 @@ func NewTokenRepository(redis *redis.Client) Token {
 	}
 }
 
-func (r *token) Blacklist(userId int, t time.Time, ttl time.Duration) error {
-	key := getBlacklistKey(userId)
-	return r.redis.Set(context.TODO(), key, t, ttl).Err()
+func (r *token) Revoke(tokenId string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil
+	}
+	key := getRevokedTokenKey(tokenId)
+	return r.redis.Set(context.TODO(), key, 1, ttl).Err()
+}
+
+func (r *token) IsRevoked(tokenId string) (bool, error) {
+	key := getRevokedTokenKey(tokenId)
+	n, err := r.redis.Exists(context.TODO(), key).Result()
+	return n > 0, err
+}
+
+func (r *token) RevokeUserTokens(userId int, before time.Time, ttl time.Duration) error {
+	key := getRevokedUserKey(userId)
+	return r.redis.Set(context.TODO(), key, before.Unix(), ttl).Err()
 }
 
-func (r *token) IsBlacklisted(userId int) (time.Time, bool, error) {
-	key := getBlacklistKey(userId)
+func (r *token) GetUserRevocation(userId int) (time.Time, bool, error) {
+	key := getRevokedUserKey(userId)
 	s, err := r.redis.Get(context.TODO(), key).Result()
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
@@ -38,17 +57,18 @@ func (r *token) IsBlacklisted(userId int) (time.Time, bool, error) {
 		}
 		return time.Time{}, false, err
 	}
-	if s == "" {
-		return time.Time{}, false, nil
-	}
-	i, err := strconv.Atoi(s)
+
+	before, err := strconv.ParseInt(s, 10, 64)
 	if err != nil {
 		return time.Time{}, false, err
 	}
-	t := time.Unix(0, int64(i))
-	return t, true, nil
+	return time.Unix(before, 0), true, nil
+}
+
+func getRevokedTokenKey(tokenId string) string {
+	return fmt.Sprintf("revoked::token::%s", tokenId)
 }
 
-func getBlacklistKey(userId int) string {
-	return fmt.Sprintf("blacklist::%d", userId)
+func getRevokedUserKey(userId int) string {
+	return fmt.Sprintf("revoked::user::%d", userId)
 }
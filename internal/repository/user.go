@@ -2,21 +2,28 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"github.com/jackc/pgx/v4"
 )
 
+var ErrUserNotFound = errors.New("user not found")
+
 type UserRepository interface {
 	CreateNewUser(firstName, lastName, email, hash string) error
 	GetUserByEmail(email string) (*User, error)
 	GetUserById(id int) (*User, error)
+	UpdatePassword(id int, hash string) error
+	SetDisabled(id int, disabled bool) error
 }
 
 type User struct {
-	Id        int    `db:"id"`
-	FirstName string `db:"first_name"`
-	LastName  string `db:"last_name"`
-	Email     string `db:"email"`
-	PassHash  string `db:"pass_hash"`
+	Id        int      `db:"id"`
+	FirstName string   `db:"first_name"`
+	LastName  string   `db:"last_name"`
+	Email     string   `db:"email"`
+	PassHash  string   `db:"pass_hash"`
+	Scopes    []string `db:"scopes"`
+	Disabled  bool     `db:"disabled"`
 }
 
 type userRepository struct {
@@ -37,15 +44,37 @@ func (r *userRepository) CreateNewUser(firstName, lastName, email, hash string)
 
 func (r *userRepository) GetUserByEmail(email string) (*User, error) {
 	user := new(User)
-	row := r.db.QueryRow(context.TODO(), "SELECT id, first_name, last_name, email, pass_hash FROM users WHERE email = $1", email)
-	err := row.Scan(&user.Id, &user.FirstName, &user.LastName, &user.Email, &user.PassHash)
+	row := r.db.QueryRow(context.TODO(), "SELECT id, first_name, last_name, email, pass_hash, scopes, disabled FROM users WHERE email = $1", email)
+	err := row.Scan(&user.Id, &user.FirstName, &user.LastName, &user.Email, &user.PassHash, &user.Scopes, &user.Disabled)
 	return user, err
 }
 
 func (r *userRepository) GetUserById(id int) (*User, error) {
 	user := new(User)
-	row := r.db.QueryRow(context.TODO(), "SELECT * FROM users WHERE id = $1", id)
-	err := row.Scan(&user.Id, &user.FirstName, &user.LastName, &user.Email, &user.PassHash)
+	row := r.db.QueryRow(context.TODO(), "SELECT id, first_name, last_name, email, pass_hash, scopes, disabled FROM users WHERE id = $1", id)
+	err := row.Scan(&user.Id, &user.FirstName, &user.LastName, &user.Email, &user.PassHash, &user.Scopes, &user.Disabled)
 
 	return user, err
 }
+
+func (r *userRepository) UpdatePassword(id int, hash string) error {
+	tag, err := r.db.Exec(context.TODO(), "UPDATE users SET pass_hash = $2 WHERE id = $1", id, hash)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (r *userRepository) SetDisabled(id int, disabled bool) error {
+	tag, err := r.db.Exec(context.TODO(), "UPDATE users SET disabled = $2 WHERE id = $1", id, disabled)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
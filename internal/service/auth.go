@@ -5,27 +5,57 @@ import (
 	"fmt"
 	"github.com/evleria/jwt-auth-demo/internal/jwt"
 	"github.com/evleria/jwt-auth-demo/internal/repository"
+	gonanoid "github.com/matoous/go-nanoid/v2"
 	"golang.org/x/crypto/bcrypt"
+	"log"
+	"strings"
 	"time"
 )
 
 type Auth interface {
 	Register(firstName, lastName, email, password string) error
-	Login(email, password string) (string, string, error)
-	Refresh(refreshToken string) (string, error)
+	Login(email, password, clientId, scope, device string, cnf *jwt.Confirmation) (string, string, error)
+	Refresh(refreshToken string, cnf *jwt.Confirmation) (string, string, error)
+	VerifyRefreshToken(refreshToken string) (*repository.Session, error)
+	Authenticate(accessToken string, audience string) (*jwt.Claims, error)
+	GetUser(userId int) (*repository.User, error)
+	GetSessions(userId int) ([]*repository.Session, error)
+	DeleteSession(userId int, sessionId string) error
+	Logout(claims *jwt.Claims) error
+	RevokeAccessToken(claims *jwt.Claims) error
+	RevokeSession(sessionId string) error
+	LogoutAll(userId int) error
+	SetPassword(userId int, password string) error
+	DisableUser(userId int) error
 }
 
+var ErrNotFound = errors.New("not found")
+
 type auth struct {
-	userRepository  repository.UserRepository
-	tokenRepository repository.Token
-	jwtMaker        jwt.Maker
+	userRepository    repository.UserRepository
+	clientRepository  repository.ClientRepository
+	roleRepository    repository.RoleRepository
+	tokenRepository   repository.Token
+	sessionRepository repository.SessionRepository
+	jwtMaker          jwt.Maker
+	refreshTokens     RefreshTokens
+	sessionPolicy     SessionPolicy
+	maxTokenSize      int
+	claimsEnrichers   []ClaimsEnricher
 }
 
-func NewAuthService(userRepository repository.UserRepository, tokenRepository repository.Token, jwtMaker jwt.Maker) *auth {
+func NewAuthService(userRepository repository.UserRepository, clientRepository repository.ClientRepository, roleRepository repository.RoleRepository, tokenRepository repository.Token, sessionRepository repository.SessionRepository, jwtMaker jwt.Maker, refreshTokens RefreshTokens, sessionPolicy SessionPolicy, maxTokenSize int, claimsEnrichers ...ClaimsEnricher) *auth {
 	return &auth{
-		userRepository:  userRepository,
-		tokenRepository: tokenRepository,
-		jwtMaker:        jwtMaker,
+		userRepository:    userRepository,
+		clientRepository:  clientRepository,
+		roleRepository:    roleRepository,
+		tokenRepository:   tokenRepository,
+		sessionRepository: sessionRepository,
+		jwtMaker:          jwtMaker,
+		refreshTokens:     refreshTokens,
+		sessionPolicy:     sessionPolicy,
+		maxTokenSize:      maxTokenSize,
+		claimsEnrichers:   claimsEnrichers,
 	}
 }
 
@@ -41,7 +71,12 @@ func (s *auth) Register(firstName, lastName, email, password string) error {
 	return nil
 }
 
-func (s *auth) Login(email, password string) (string, string, error) {
+// Login authenticates a user and starts a session. The access tokens of the
+// session carry the requested scope narrowed down to the scopes the user is
+// allowed, or all of them if no scope is requested. Given a confirmation,
+// the tokens of the session are bound to the key or the certificate it
+// names.
+func (s *auth) Login(email, password, clientId, scope, device string, cnf *jwt.Confirmation) (string, string, error) {
 	user, err := s.userRepository.GetUserByEmail(email)
 	if err != nil {
 		return "", "", errors.New("cannot find user")
@@ -51,46 +86,418 @@ func (s *auth) Login(email, password string) (string, string, error) {
 	if err != nil {
 		return "", "", errors.New("invalid password provided")
 	}
+	if user.Disabled {
+		return "", "", errors.New("user is disabled")
+	}
+
+	client, err := s.getClient(clientId)
+	if err != nil {
+		return "", "", err
+	}
+	policy := s.sessionPolicy.forClient(client)
 
-	accessToken, err := s.jwtMaker.GenerateAccessToken(user.Id, user.Email)
+	sessionId, err := gonanoid.New()
+	if err != nil {
+		return "", "", errors.New("cannot create session")
+	}
+
+	now := time.Now()
+	session := &repository.Session{
+		Id:         sessionId,
+		UserId:     user.Id,
+		ClientId:   clientId,
+		Device:     device,
+		Scope:      scope,
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(policy.MaxLifetime),
+	}
+	if cnf != nil {
+		session.JwkThumbprint = cnf.JwkThumbprint
+		session.CertificateThumbprint = cnf.CertificateThumbprint
+	}
+	accessToken, err := s.generateAccessToken(user, client, session, cnf)
 	if err != nil {
-		return "", "", errors.New("cannot generate access token")
+		return "", "", err
 	}
 
-	refreshToken, err := s.jwtMaker.GenerateRefreshToken(user.Id)
+	refreshToken, tokenId, expiresAt, err := s.refreshTokens.Generate(session, policy.refreshTokenExpiry(session, now))
 	if err != nil {
 		return "", "", errors.New("cannot generate refresh token")
 	}
 
+	session.TokenId = tokenId
+	err = s.sessionRepository.CreateSession(session, time.Until(expiresAt))
+	if err != nil {
+		return "", "", errors.New("cannot create session")
+	}
+
 	return accessToken, refreshToken, nil
 }
 
-func (s *auth) Refresh(refreshToken string) (string, error) {
-	claims, err := s.jwtMaker.VerifyRefreshToken(refreshToken)
+// Refresh rotates the refresh token of a session and issues a new access
+// token. Every refresh extends the session by the idle timeout of its
+// client, up to the maximum lifetime of the session. The refresh tokens of
+// a session bound to a key or a certificate can only be used with a
+// confirmation of the same one.
+func (s *auth) Refresh(refreshToken string, cnf *jwt.Confirmation) (string, string, error) {
+	session, tokenId, err := s.loadRefreshSession(refreshToken)
+	if err != nil {
+		return "", "", err
+	}
+	err = checkSessionBinding(session, cnf)
+	if err != nil {
+		return "", "", err
+	}
+
+	client, err := s.getClient(session.ClientId)
+	if err != nil {
+		return "", "", err
+	}
+	policy := s.sessionPolicy.forClient(client)
+	now := time.Now()
+	err = policy.check(session, now)
+	if err != nil {
+		if err := s.sessionRepository.DeleteSession(session.Id); err != nil {
+			return "", "", err
+		}
+		return "", "", err
+	}
+
+	user, err := s.userRepository.GetUserById(session.UserId)
+	if err != nil {
+		return "", "", errors.New("cannot find user")
+	}
+	if user.Disabled {
+		return "", "", errors.New("user is disabled")
+	}
+
+	nextRefreshToken, nextTokenId, expiresAt, err := s.refreshTokens.Generate(session, policy.refreshTokenExpiry(session, now))
 	if err != nil {
-		return "", err
+		return "", "", errors.New("cannot generate refresh token")
 	}
 
-	userId := int(claims["sub"].(float64))
-	t, inBlacklist, err := s.tokenRepository.IsBlacklisted(userId)
+	rotated, err := s.sessionRepository.RotateSessionToken(session.Id, tokenId, nextTokenId, time.Until(expiresAt))
+	if errors.Is(err, repository.ErrSessionNotFound) {
+		return "", "", errors.New("session is revoked")
+	}
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
-	if inBlacklist {
-		iat := time.Unix(int64(claims["iat"].(int)), 0)
-		if t.After(iat) {
-			return "", errors.New("token is blacklisted")
+	if !rotated {
+		log.Printf("security: refresh token of user %d reused, revoking session %s", session.UserId, session.Id)
+		if err := s.sessionRepository.DeleteSession(session.Id); err != nil {
+			return "", "", err
 		}
+		return "", "", errors.New("refresh token has already been used")
+	}
+
+	accessToken, err := s.generateAccessToken(user, client, session, cnf)
+	if err != nil {
+		return "", "", err
+	}
+	return accessToken, nextRefreshToken, nil
+}
+
+// VerifyRefreshToken returns the session of a refresh token if the token is
+// the latest one of a session that has not been revoked.
+func (s *auth) VerifyRefreshToken(refreshToken string) (*repository.Session, error) {
+	session, tokenId, err := s.loadRefreshSession(refreshToken)
+	if err != nil {
+		return nil, err
 	}
+	if session.TokenId != tokenId {
+		return nil, errors.New("refresh token has already been used")
+	}
+	return session, nil
+}
 
+func (s *auth) Authenticate(accessToken string, audience string) (*jwt.Claims, error) {
+	claims, err := s.jwtMaker.VerifyAccessToken(accessToken, audience)
+	if err != nil {
+		return nil, err
+	}
+
+	revoked, err := s.tokenRepository.IsRevoked(claims.Id)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, errors.New("token is revoked")
+	}
+
+	err = s.checkUserRevocation(claims.Subject, claims.IssuedAtTime())
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.SessionId != "" {
+		exists, err := s.sessionRepository.SessionExists(claims.SessionId)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, errors.New("session is revoked")
+		}
+	}
+	return claims, nil
+}
+
+func (s *auth) GetUser(userId int) (*repository.User, error) {
 	user, err := s.userRepository.GetUserById(userId)
 	if err != nil {
-		return "", errors.New("cannot find user")
+		return nil, errors.New("cannot find user")
 	}
+	return user, nil
+}
+
+func (s *auth) GetSessions(userId int) ([]*repository.Session, error) {
+	sessions, err := s.sessionRepository.GetUserSessions(userId)
+	if err != nil {
+		return nil, errors.New("cannot load sessions")
+	}
+	return sessions, nil
+}
+
+func (s *auth) DeleteSession(userId int, sessionId string) error {
+	session, err := s.sessionRepository.GetSession(sessionId)
+	if errors.Is(err, repository.ErrSessionNotFound) || (err == nil && session.UserId != userId) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return s.sessionRepository.DeleteSession(sessionId)
+}
+
+// Logout revokes the session of the access token along with the token
+// itself.
+func (s *auth) Logout(claims *jwt.Claims) error {
+	err := s.RevokeAccessToken(claims)
+	if err != nil {
+		return err
+	}
+	return s.RevokeSession(claims.SessionId)
+}
+
+func (s *auth) RevokeAccessToken(claims *jwt.Claims) error {
+	err := s.tokenRepository.Revoke(claims.Id, s.remainingLifetime(claims))
+	if err != nil {
+		return errors.New("cannot revoke token")
+	}
+	return nil
+}
+
+// RevokeSession revokes the refresh tokens of a session and the access
+// tokens issued within it.
+func (s *auth) RevokeSession(sessionId string) error {
+	err := s.sessionRepository.DeleteSession(sessionId)
+	if err != nil {
+		return errors.New("cannot revoke session")
+	}
+	return nil
+}
 
-	accessToken, err := s.jwtMaker.GenerateAccessToken(user.Id, user.Email)
+// LogoutAll revokes every token issued to the user so far: refresh tokens
+// by deleting their sessions and access tokens through the user cutoff.
+// Issue times have a second precision, so does the cutoff: tokens issued
+// within the second of the cutoff are revoked as well.
+func (s *auth) LogoutAll(userId int) error {
+	err := s.tokenRepository.RevokeUserTokens(userId, time.Now(), s.jwtMaker.AccessTokenLifetime())
 	if err != nil {
+		return errors.New("cannot revoke tokens")
+	}
+
+	err = s.sessionRepository.DeleteUserSessions(userId)
+	if err != nil {
+		return errors.New("cannot revoke sessions")
+	}
+	return nil
+}
+
+// SetPassword replaces the password of the user and logs the user out
+// everywhere, so that whoever knew the old password loses access.
+func (s *auth) SetPassword(userId int, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("cannot set password: %v", err)
+	}
+	err = s.userRepository.UpdatePassword(userId, string(hash))
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return errors.New("cannot set password")
+	}
+	return s.LogoutAll(userId)
+}
+
+// DisableUser stops the user from logging in and revokes every token
+// issued to the user.
+func (s *auth) DisableUser(userId int) error {
+	err := s.userRepository.SetDisabled(userId, true)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return errors.New("cannot disable user")
+	}
+	return s.LogoutAll(userId)
+}
+
+func (s *auth) loadRefreshSession(refreshToken string) (*repository.Session, string, error) {
+	sessionId, tokenId, err := s.refreshTokens.Parse(refreshToken)
+	if err != nil {
+		return nil, "", err
+	}
+
+	session, err := s.sessionRepository.GetSession(sessionId)
+	if errors.Is(err, repository.ErrSessionNotFound) {
+		return nil, "", errors.New("session is revoked")
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	err = s.checkUserRevocation(session.UserId, session.CreatedAt)
+	if err != nil {
+		return nil, "", err
+	}
+	return session, tokenId, nil
+}
+
+func checkSessionBinding(session *repository.Session, cnf *jwt.Confirmation) error {
+	if cnf == nil {
+		cnf = &jwt.Confirmation{}
+	}
+	if session.JwkThumbprint != "" && cnf.JwkThumbprint != session.JwkThumbprint {
+		return errors.New("refresh token is bound to another key")
+	}
+	if session.CertificateThumbprint != "" && cnf.CertificateThumbprint != session.CertificateThumbprint {
+		return errors.New("refresh token is bound to another certificate")
+	}
+	return nil
+}
+
+func (s *auth) checkUserRevocation(userId int, issuedAt time.Time) error {
+	before, revoked, err := s.tokenRepository.GetUserRevocation(userId)
+	if err != nil {
+		return err
+	}
+	if revoked && !issuedAt.After(before) {
+		return errors.New("token is revoked")
+	}
+	return nil
+}
+
+// remainingLifetime is how long an access token is still accepted for.
+func (s *auth) remainingLifetime(claims *jwt.Claims) time.Duration {
+	return time.Until(claims.IssuedAtTime().Add(s.jwtMaker.AccessTokenLifetime()))
+}
+
+// generateAccessToken issues an access token within a session. The scope,
+// roles and permissions are loaded anew every time, so changes to them take
+// effect on the next refresh.
+func (s *auth) generateAccessToken(user *repository.User, client *repository.Client, session *repository.Session, cnf *jwt.Confirmation) (string, error) {
+	// The server's own audience is always included, so that tokens of
+	// clients with audiences of their own still work on the auth routes.
+	audience := jwt.Audience{s.jwtMaker.Audience()}
+	if client != nil {
+		for _, aud := range client.Audiences {
+			if !audience.Contains(aud) {
+				audience = append(audience, aud)
+			}
+		}
+	}
+
+	roles, err := s.roleRepository.GetUserRoles(user.Id)
+	if err != nil {
+		return "", errors.New("cannot load roles")
+	}
+	permissions, err := s.roleRepository.GetUserPermissions(user.Id)
+	if err != nil {
+		return "", errors.New("cannot load permissions")
+	}
+
+	claims := &jwt.Claims{
+		Subject:      user.Id,
+		Email:        user.Email,
+		Audience:     audience,
+		ClientId:     session.ClientId,
+		SessionId:    session.Id,
+		Scope:        grantScope(session.Scope, user.Scopes),
+		Roles:        roles,
+		Permissions:  permissions,
+		Confirmation: cnf,
+	}
+	err = s.enrichClaims(user, claims)
+	if err != nil {
+		log.Println(err)
 		return "", errors.New("cannot generate access token")
 	}
-	return accessToken, err
+
+	accessToken, err := s.jwtMaker.GenerateAccessToken(claims)
+	if err != nil {
+		return "", errors.New("cannot generate access token")
+	}
+	if s.maxTokenSize > 0 && len(accessToken) > s.maxTokenSize {
+		log.Printf("access token of user %d is %d bytes long, the limit is %d", user.Id, len(accessToken), s.maxTokenSize)
+		return "", errors.New("access token is too large")
+	}
+	return accessToken, nil
+}
+
+// enrichClaims runs the claims enrichers in order, each one seeing the
+// claims added by the previous ones.
+func (s *auth) enrichClaims(user *repository.User, claims *jwt.Claims) error {
+	for i, enricher := range s.claimsEnrichers {
+		extra, err := enricher.Enrich(user, claims)
+		if err != nil {
+			return fmt.Errorf("claims enricher %d: %v", i, err)
+		}
+		for name, value := range extra {
+			if jwt.IsRegisteredClaim(name) {
+				return fmt.Errorf("claims enricher %d: cannot set registered claim %q", i, name)
+			}
+			if claims.Extra == nil {
+				claims.Extra = make(map[string]interface{})
+			}
+			claims.Extra[name] = value
+		}
+	}
+	return nil
+}
+
+// getClient loads the client a session has been started by, if any.
+func (s *auth) getClient(clientId string) (*repository.Client, error) {
+	if clientId == "" {
+		return nil, nil
+	}
+	client, err := s.clientRepository.GetClientById(clientId)
+	if err != nil {
+		return nil, errors.New("cannot find client")
+	}
+	return client, nil
+}
+
+// grantScope intersects the requested space-delimited scope with the
+// allowed ones. An empty request is granted every allowed scope.
+func grantScope(requested string, allowed []string) string {
+	if strings.TrimSpace(requested) == "" {
+		return strings.Join(allowed, " ")
+	}
+
+	isAllowed := make(map[string]bool, len(allowed))
+	for _, scope := range allowed {
+		isAllowed[scope] = true
+	}
+
+	granted := make([]string, 0, len(allowed))
+	for _, scope := range strings.Fields(requested) {
+		if isAllowed[scope] {
+			granted = append(granted, scope)
+			isAllowed[scope] = false
+		}
+	}
+	return strings.Join(granted, " ")
 }
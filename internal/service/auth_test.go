@@ -0,0 +1,358 @@
+package service
+
+import (
+	"errors"
+	"github.com/evleria/jwt-auth-demo/internal/jwt"
+	"github.com/evleria/jwt-auth-demo/internal/repository"
+	"golang.org/x/crypto/bcrypt"
+	"testing"
+	"time"
+)
+
+const (
+	authTestEmail    = "jane@example.com"
+	authTestPassword = "password"
+)
+
+type memoryUserRepository map[int]*repository.User
+
+func (r memoryUserRepository) CreateNewUser(firstName, lastName, email, hash string) error {
+	id := len(r) + 1
+	r[id] = &repository.User{Id: id, FirstName: firstName, LastName: lastName, Email: email, PassHash: hash}
+	return nil
+}
+
+func (r memoryUserRepository) GetUserByEmail(email string) (*repository.User, error) {
+	for _, user := range r {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return nil, repository.ErrUserNotFound
+}
+
+func (r memoryUserRepository) GetUserById(id int) (*repository.User, error) {
+	user, ok := r[id]
+	if !ok {
+		return nil, repository.ErrUserNotFound
+	}
+	return user, nil
+}
+
+func (r memoryUserRepository) UpdatePassword(id int, hash string) error {
+	user, ok := r[id]
+	if !ok {
+		return repository.ErrUserNotFound
+	}
+	user.PassHash = hash
+	return nil
+}
+
+func (r memoryUserRepository) SetDisabled(id int, disabled bool) error {
+	user, ok := r[id]
+	if !ok {
+		return repository.ErrUserNotFound
+	}
+	user.Disabled = disabled
+	return nil
+}
+
+type memoryClientRepository map[string]*repository.Client
+
+func (r memoryClientRepository) GetClientById(id string) (*repository.Client, error) {
+	client, ok := r[id]
+	if !ok {
+		return nil, errors.New("no rows in result set")
+	}
+	return client, nil
+}
+
+// memoryRoleRepository assigns no roles to anyone.
+type memoryRoleRepository struct {
+	repository.RoleRepository
+}
+
+func (r memoryRoleRepository) GetUserRoles(userId int) ([]string, error) {
+	return nil, nil
+}
+
+func (r memoryRoleRepository) GetUserPermissions(userId int) ([]string, error) {
+	return nil, nil
+}
+
+// memoryTokenRepository keeps user cutoffs with a second precision, like
+// the Redis repository does.
+type memoryTokenRepository struct {
+	revoked map[string]bool
+	cutoffs map[int]time.Time
+}
+
+func newMemoryTokenRepository() *memoryTokenRepository {
+	return &memoryTokenRepository{
+		revoked: make(map[string]bool),
+		cutoffs: make(map[int]time.Time),
+	}
+}
+
+func (r *memoryTokenRepository) Revoke(tokenId string, ttl time.Duration) error {
+	r.revoked[tokenId] = true
+	return nil
+}
+
+func (r *memoryTokenRepository) IsRevoked(tokenId string) (bool, error) {
+	return r.revoked[tokenId], nil
+}
+
+func (r *memoryTokenRepository) RevokeUserTokens(userId int, before time.Time, ttl time.Duration) error {
+	r.cutoffs[userId] = time.Unix(before.Unix(), 0)
+	return nil
+}
+
+func (r *memoryTokenRepository) GetUserRevocation(userId int) (time.Time, bool, error) {
+	before, ok := r.cutoffs[userId]
+	return before, ok, nil
+}
+
+type memorySessionRepository map[string]*repository.Session
+
+func (r memorySessionRepository) CreateSession(session *repository.Session, ttl time.Duration) error {
+	stored := *session
+	r[session.Id] = &stored
+	return nil
+}
+
+func (r memorySessionRepository) GetSession(sessionId string) (*repository.Session, error) {
+	session, ok := r[sessionId]
+	if !ok {
+		return nil, repository.ErrSessionNotFound
+	}
+	loaded := *session
+	return &loaded, nil
+}
+
+func (r memorySessionRepository) SessionExists(sessionId string) (bool, error) {
+	_, ok := r[sessionId]
+	return ok, nil
+}
+
+func (r memorySessionRepository) GetUserSessions(userId int) ([]*repository.Session, error) {
+	sessions := make([]*repository.Session, 0)
+	for _, session := range r {
+		if session.UserId == userId {
+			loaded := *session
+			sessions = append(sessions, &loaded)
+		}
+	}
+	return sessions, nil
+}
+
+func (r memorySessionRepository) RotateSessionToken(sessionId, tokenId, nextTokenId string, ttl time.Duration) (bool, error) {
+	session, ok := r[sessionId]
+	if !ok {
+		return false, repository.ErrSessionNotFound
+	}
+	if session.TokenId != tokenId {
+		return false, nil
+	}
+	session.TokenId = nextTokenId
+	session.LastUsedAt = time.Now()
+	return true, nil
+}
+
+func (r memorySessionRepository) DeleteSession(sessionId string) error {
+	delete(r, sessionId)
+	return nil
+}
+
+func (r memorySessionRepository) DeleteUserSessions(userId int) error {
+	for id, session := range r {
+		if session.UserId == userId {
+			delete(r, id)
+		}
+	}
+	return nil
+}
+
+type authTestRepositories struct {
+	users    memoryUserRepository
+	clients  memoryClientRepository
+	tokens   *memoryTokenRepository
+	sessions memorySessionRepository
+}
+
+// newAuthTestService makes an auth service backed by memory repositories
+// that knows a single user, authTestEmail.
+func newAuthTestService(t *testing.T, refreshTokens func(jwt.Maker) RefreshTokens) (*auth, *authTestRepositories) {
+	t.Helper()
+	jwtMaker, err := jwt.NewMakerFromConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(authTestPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repositories := &authTestRepositories{
+		users:    memoryUserRepository{1: {Id: 1, Email: authTestEmail, PassHash: string(hash)}},
+		clients:  memoryClientRepository{},
+		tokens:   newMemoryTokenRepository(),
+		sessions: memorySessionRepository{},
+	}
+	policy := SessionPolicy{IdleTimeout: time.Hour, MaxLifetime: time.Hour * 24}
+	s := NewAuthService(repositories.users, repositories.clients, memoryRoleRepository{}, repositories.tokens, repositories.sessions,
+		jwtMaker, refreshTokens(jwtMaker), policy, 0)
+	return s, repositories
+}
+
+func TestRefreshRotatesTokens(t *testing.T) {
+	formats := []struct {
+		name          string
+		refreshTokens func(jwt.Maker) RefreshTokens
+	}{
+		{name: "jwt", refreshTokens: NewJwtRefreshTokens},
+		{name: "opaque", refreshTokens: func(jwt.Maker) RefreshTokens { return NewOpaqueRefreshTokens() }},
+	}
+
+	for _, format := range formats {
+		t.Run(format.name, func(t *testing.T) {
+			s, repositories := newAuthTestService(t, format.refreshTokens)
+			_, first, err := s.Login(authTestEmail, authTestPassword, "", "", "test", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			accessToken, second, err := s.Refresh(first, nil)
+			if err != nil {
+				t.Fatalf("got error %v on the first refresh, want none", err)
+			}
+			if second == first {
+				t.Fatal("refresh token has not been rotated")
+			}
+			if _, err := s.VerifyRefreshToken(first); err == nil {
+				t.Fatal("rotated refresh token is still valid")
+			}
+			if _, err := s.VerifyRefreshToken(second); err != nil {
+				t.Fatalf("got error %v for the latest refresh token, want none", err)
+			}
+
+			if _, _, err := s.Refresh(first, nil); err == nil {
+				t.Fatal("reused refresh token has been accepted")
+			}
+			if len(repositories.sessions) != 0 {
+				t.Fatal("reuse has not revoked the session")
+			}
+			if _, _, err := s.Refresh(second, nil); err == nil {
+				t.Fatal("latest refresh token of a revoked session has been accepted")
+			}
+			if _, err := s.Authenticate(accessToken, ""); err == nil {
+				t.Fatal("access token of a revoked session has been accepted")
+			}
+		})
+	}
+}
+
+func TestAuthenticateRevocation(t *testing.T) {
+	tests := []struct {
+		name   string
+		revoke func(s *auth, repositories *authTestRepositories, claims *jwt.Claims) error
+		ok     bool
+	}{
+		{
+			name:   "valid token",
+			revoke: func(s *auth, repositories *authTestRepositories, claims *jwt.Claims) error { return nil },
+			ok:     true,
+		},
+		{
+			name: "revoked token id",
+			revoke: func(s *auth, repositories *authTestRepositories, claims *jwt.Claims) error {
+				return s.RevokeAccessToken(claims)
+			},
+		},
+		{
+			name: "another revoked token id",
+			revoke: func(s *auth, repositories *authTestRepositories, claims *jwt.Claims) error {
+				return repositories.tokens.Revoke("another-token", time.Minute)
+			},
+			ok: true,
+		},
+		{
+			name: "user cutoff within the second of issue",
+			revoke: func(s *auth, repositories *authTestRepositories, claims *jwt.Claims) error {
+				return repositories.tokens.RevokeUserTokens(claims.Subject, claims.IssuedAtTime().Add(time.Millisecond*999), time.Minute)
+			},
+		},
+		{
+			name: "user cutoff after issue",
+			revoke: func(s *auth, repositories *authTestRepositories, claims *jwt.Claims) error {
+				return repositories.tokens.RevokeUserTokens(claims.Subject, claims.IssuedAtTime().Add(time.Second), time.Minute)
+			},
+		},
+		{
+			name: "user cutoff before issue",
+			revoke: func(s *auth, repositories *authTestRepositories, claims *jwt.Claims) error {
+				return repositories.tokens.RevokeUserTokens(claims.Subject, claims.IssuedAtTime().Add(-time.Second), time.Minute)
+			},
+			ok: true,
+		},
+		{
+			name: "cutoff of another user",
+			revoke: func(s *auth, repositories *authTestRepositories, claims *jwt.Claims) error {
+				return repositories.tokens.RevokeUserTokens(claims.Subject+1, claims.IssuedAtTime().Add(time.Second), time.Minute)
+			},
+			ok: true,
+		},
+		{
+			name: "logout everywhere",
+			revoke: func(s *auth, repositories *authTestRepositories, claims *jwt.Claims) error {
+				return s.LogoutAll(claims.Subject)
+			},
+		},
+		{
+			name: "revoked session",
+			revoke: func(s *auth, repositories *authTestRepositories, claims *jwt.Claims) error {
+				return s.RevokeSession(claims.SessionId)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, repositories := newAuthTestService(t, NewJwtRefreshTokens)
+			accessToken, _, err := s.Login(authTestEmail, authTestPassword, "", "", "test", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			claims, err := s.Authenticate(accessToken, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := test.revoke(s, repositories, claims); err != nil {
+				t.Fatal(err)
+			}
+			_, err = s.Authenticate(accessToken, "")
+			if test.ok && err != nil {
+				t.Fatalf("got error %v, want none", err)
+			}
+			if !test.ok && err == nil {
+				t.Fatal("revoked token has been accepted")
+			}
+		})
+	}
+}
+
+func TestLogoutAllRevokesRefreshTokens(t *testing.T) {
+	s, _ := newAuthTestService(t, NewJwtRefreshTokens)
+	_, refreshToken, err := s.Login(authTestEmail, authTestPassword, "", "", "test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.LogoutAll(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Refresh(refreshToken, nil); err == nil {
+		t.Fatal("refresh token issued before logging out everywhere has been accepted")
+	}
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"github.com/evleria/jwt-auth-demo/internal/config"
+	"github.com/evleria/jwt-auth-demo/internal/jwt"
+	"github.com/evleria/jwt-auth-demo/internal/repository"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidDPoPProof  = errors.New("invalid DPoP proof")
+	ErrDPoPNonceRequired = errors.New("DPoP nonce required")
+)
+
+// DPoP verifies DPoP proofs (RFC 9449) and hands out the nonces they have
+// to carry when nonces are required.
+type DPoP interface {
+	VerifyProof(proof, method, url, accessToken string) (*jwt.DPoPProof, error)
+	Nonce() string
+}
+
+type dpop struct {
+	proofRepository repository.ProofRepository
+	proofLifetime   time.Duration
+	nonceKey        []byte
+	nonceLifetime   time.Duration
+}
+
+// NewDPoPServiceFromConfig reads DPOP_PROOF_LIFETIME, how far the issue time
+// of a proof may be off, and whether DPOP_NONCE_REQUIRED. Nonces are derived
+// from DPOP_NONCE_SECRET and change every DPOP_NONCE_LIFETIME; instances
+// behind a load balancer have to share the secret.
+func NewDPoPServiceFromConfig(proofRepository repository.ProofRepository) (*dpop, error) {
+	service := &dpop{
+		proofRepository: proofRepository,
+		proofLifetime:   config.GetDuration("DPOP_PROOF_LIFETIME", time.Minute),
+		nonceLifetime:   config.GetDuration("DPOP_NONCE_LIFETIME", time.Minute*5),
+	}
+
+	if config.GetBool("DPOP_NONCE_REQUIRED", false) {
+		service.nonceKey = []byte(config.GetString("DPOP_NONCE_SECRET", ""))
+		if len(service.nonceKey) == 0 {
+			service.nonceKey = make([]byte, 32)
+			if _, err := rand.Read(service.nonceKey); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return service, nil
+}
+
+// VerifyProof verifies a proof sent with a request. The proof of a request
+// to a protected resource has to be bound to the access token, too.
+func (s *dpop) VerifyProof(proof, method, requestUrl, accessToken string) (*jwt.DPoPProof, error) {
+	p, err := jwt.ParseDPoPProof(proof)
+	if err != nil {
+		return nil, ErrInvalidDPoPProof
+	}
+
+	if p.Method != method || !sameUrl(p.URL, requestUrl) {
+		return nil, ErrInvalidDPoPProof
+	}
+	issuedAt := time.Unix(p.IssuedAt, 0)
+	if time.Since(issuedAt) > s.proofLifetime || time.Until(issuedAt) > s.proofLifetime {
+		return nil, ErrInvalidDPoPProof
+	}
+	if accessToken != "" && p.AccessTokenHash != jwt.AccessTokenHash(accessToken) {
+		return nil, ErrInvalidDPoPProof
+	}
+	if s.nonceKey != nil && !s.isValidNonce(p.Nonce) {
+		return nil, ErrDPoPNonceRequired
+	}
+
+	fresh, err := s.proofRepository.Use(p.Id, s.proofLifetime*2)
+	if err != nil {
+		return nil, err
+	}
+	if !fresh {
+		return nil, ErrInvalidDPoPProof
+	}
+	return p, nil
+}
+
+// Nonce returns the current nonce, or an empty string when nonces are not
+// required.
+func (s *dpop) Nonce() string {
+	if s.nonceKey == nil {
+		return ""
+	}
+	return s.makeNonce(s.nonceWindow())
+}
+
+// isValidNonce accepts the current nonce and the previous one, so that a
+// nonce lives for at least DPOP_NONCE_LIFETIME.
+func (s *dpop) isValidNonce(nonce string) bool {
+	window := s.nonceWindow()
+	return hmac.Equal([]byte(nonce), []byte(s.makeNonce(window))) ||
+		hmac.Equal([]byte(nonce), []byte(s.makeNonce(window-1)))
+}
+
+func (s *dpop) nonceWindow() int64 {
+	return time.Now().UnixNano() / int64(s.nonceLifetime)
+}
+
+func (s *dpop) makeNonce(window int64) string {
+	mac := hmac.New(sha256.New, s.nonceKey)
+	binary.Write(mac, binary.BigEndian, window)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// sameUrl compares the "htu" claim of a proof to the request URL ignoring
+// the query, the fragment and the case of the scheme and the host.
+func sameUrl(htu, requestUrl string) bool {
+	a, err := url.Parse(htu)
+	if err != nil {
+		return false
+	}
+	b, err := url.Parse(requestUrl)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(a.Scheme, b.Scheme) && strings.EqualFold(a.Host, b.Host) && a.EscapedPath() == b.EscapedPath()
+}
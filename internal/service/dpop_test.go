@@ -0,0 +1,147 @@
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"github.com/evleria/jwt-auth-demo/internal/jwt"
+	"testing"
+	"time"
+)
+
+const (
+	dpopTestMethod      = "POST"
+	dpopTestUrl         = "https://auth.example.com/auth/me"
+	dpopTestAccessToken = "access-token"
+)
+
+type memoryProofRepository map[string]bool
+
+func (r memoryProofRepository) Use(proofId string, ttl time.Duration) (bool, error) {
+	if r[proofId] {
+		return false, nil
+	}
+	r[proofId] = true
+	return true, nil
+}
+
+func TestVerifyProof(t *testing.T) {
+	key := newDPoPTestKey(t)
+	validProof := func() *jwt.DPoPProof {
+		return &jwt.DPoPProof{
+			Id:              "proof-id",
+			Method:          dpopTestMethod,
+			URL:             dpopTestUrl,
+			IssuedAt:        time.Now().Unix(),
+			AccessTokenHash: jwt.AccessTokenHash(dpopTestAccessToken),
+		}
+	}
+
+	tests := []struct {
+		name     string
+		proof    func(p *jwt.DPoPProof)
+		used     bool
+		url      string
+		expected error
+	}{
+		{name: "valid"},
+		{name: "query and case of the url are ignored", url: "HTTPS://Auth.Example.com/auth/me?page=2"},
+		{name: "htm mismatch", proof: func(p *jwt.DPoPProof) { p.Method = "GET" }, expected: ErrInvalidDPoPProof},
+		{name: "htu path mismatch", proof: func(p *jwt.DPoPProof) { p.URL = "https://auth.example.com/auth/sessions" }, expected: ErrInvalidDPoPProof},
+		{name: "htu host mismatch", proof: func(p *jwt.DPoPProof) { p.URL = "https://evil.example.com/auth/me" }, expected: ErrInvalidDPoPProof},
+		{name: "htu scheme mismatch", proof: func(p *jwt.DPoPProof) { p.URL = "http://auth.example.com/auth/me" }, expected: ErrInvalidDPoPProof},
+		{name: "stale iat", proof: func(p *jwt.DPoPProof) { p.IssuedAt = time.Now().Add(-time.Minute * 2).Unix() }, expected: ErrInvalidDPoPProof},
+		{name: "future iat", proof: func(p *jwt.DPoPProof) { p.IssuedAt = time.Now().Add(time.Minute * 2).Unix() }, expected: ErrInvalidDPoPProof},
+		{name: "replayed jti", used: true, expected: ErrInvalidDPoPProof},
+		{name: "wrong ath", proof: func(p *jwt.DPoPProof) { p.AccessTokenHash = jwt.AccessTokenHash("other-token") }, expected: ErrInvalidDPoPProof},
+		{name: "missing ath", proof: func(p *jwt.DPoPProof) { p.AccessTokenHash = "" }, expected: ErrInvalidDPoPProof},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			proof := validProof()
+			if test.proof != nil {
+				test.proof(proof)
+			}
+			requestUrl := dpopTestUrl
+			if test.url != "" {
+				requestUrl = test.url
+			}
+			repository := memoryProofRepository{proof.Id: test.used}
+			service := &dpop{proofRepository: repository, proofLifetime: time.Minute}
+
+			verified, err := service.VerifyProof(signDPoPProof(t, key, proof), dpopTestMethod, requestUrl, dpopTestAccessToken)
+			if !errors.Is(err, test.expected) {
+				t.Fatalf("got error %v, want %v", err, test.expected)
+			}
+			if err == nil && verified.JwkThumbprint != dpopTestThumbprint(t, key) {
+				t.Fatalf("got jkt %s, want the thumbprint of the proof key", verified.JwkThumbprint)
+			}
+		})
+	}
+}
+
+func TestVerifyProofRejectsReplays(t *testing.T) {
+	key := newDPoPTestKey(t)
+	service := &dpop{proofRepository: memoryProofRepository{}, proofLifetime: time.Minute}
+	proof := signDPoPProof(t, key, &jwt.DPoPProof{
+		Id:       "proof-id",
+		Method:   dpopTestMethod,
+		URL:      dpopTestUrl,
+		IssuedAt: time.Now().Unix(),
+	})
+
+	if _, err := service.VerifyProof(proof, dpopTestMethod, dpopTestUrl, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.VerifyProof(proof, dpopTestMethod, dpopTestUrl, ""); !errors.Is(err, ErrInvalidDPoPProof) {
+		t.Fatalf("got error %v for a replayed proof, want %v", err, ErrInvalidDPoPProof)
+	}
+}
+
+func newDPoPTestKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func signDPoPProof(t *testing.T, key *ecdsa.PrivateKey, proof *jwt.DPoPProof) string {
+	jwk, err := jwt.NewJSONWebKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(jwk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	members := make(map[string]interface{})
+	if err := json.Unmarshal(data, &members); err != nil {
+		t.Fatal(err)
+	}
+
+	token := jwtgo.NewWithClaims(jwtgo.SigningMethodES256, proof)
+	token.Header["typ"] = "dpop+jwt"
+	token.Header["jwk"] = members
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func dpopTestThumbprint(t *testing.T, key *ecdsa.PrivateKey) string {
+	jwk, err := jwt.NewJSONWebKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	thumbprint, err := jwk.Thumbprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return thumbprint
+}
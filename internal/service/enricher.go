@@ -0,0 +1,64 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/evleria/jwt-auth-demo/internal/config"
+	"github.com/evleria/jwt-auth-demo/internal/jwt"
+	"github.com/evleria/jwt-auth-demo/internal/repository"
+	"log"
+)
+
+// ClaimsEnricher adds custom claims to access tokens. Enrichers run in the
+// order they are given to the auth service, see the claims set by the ones
+// before them and override them on conflicts. The claims must not be
+// modified; the returned claims are added to Extra instead. Registered
+// claims cannot be set.
+//
+// An error fails the issuance of the token unless the enricher is wrapped
+// with OptionalClaims.
+type ClaimsEnricher interface {
+	Enrich(user *repository.User, claims *jwt.Claims) (map[string]interface{}, error)
+}
+
+type ClaimsEnricherFunc func(user *repository.User, claims *jwt.Claims) (map[string]interface{}, error)
+
+func (f ClaimsEnricherFunc) Enrich(user *repository.User, claims *jwt.Claims) (map[string]interface{}, error) {
+	return f(user, claims)
+}
+
+// OptionalClaims makes an enricher whose errors are logged and the token is
+// issued without its claims.
+func OptionalClaims(enricher ClaimsEnricher) ClaimsEnricher {
+	return ClaimsEnricherFunc(func(user *repository.User, claims *jwt.Claims) (map[string]interface{}, error) {
+		extra, err := enricher.Enrich(user, claims)
+		if err != nil {
+			log.Printf("claims enricher failed for user %d: %v", user.Id, err)
+			return nil, nil
+		}
+		return extra, nil
+	})
+}
+
+// StaticClaims adds the same claims to every access token.
+func StaticClaims(extra map[string]interface{}) ClaimsEnricher {
+	return ClaimsEnricherFunc(func(*repository.User, *jwt.Claims) (map[string]interface{}, error) {
+		return extra, nil
+	})
+}
+
+// NewClaimsEnrichersFromConfig makes the enrichers configured through the
+// environment: ACCESS_TOKEN_EXTRA_CLAIMS holds a JSON object of claims added
+// to every access token.
+func NewClaimsEnrichersFromConfig() ([]ClaimsEnricher, error) {
+	var enrichers []ClaimsEnricher
+
+	if extraClaims := config.GetString("ACCESS_TOKEN_EXTRA_CLAIMS", ""); extraClaims != "" {
+		var extra map[string]interface{}
+		if err := json.Unmarshal([]byte(extraClaims), &extra); err != nil {
+			return nil, fmt.Errorf("ACCESS_TOKEN_EXTRA_CLAIMS: %v", err)
+		}
+		enrichers = append(enrichers, StaticClaims(extra))
+	}
+	return enrichers, nil
+}
@@ -0,0 +1,254 @@
+package service
+
+import (
+	"errors"
+	"github.com/evleria/jwt-auth-demo/internal/jwt"
+	"github.com/evleria/jwt-auth-demo/internal/repository"
+	"golang.org/x/crypto/bcrypt"
+	"log"
+	"strconv"
+)
+
+const (
+	AccessTokenType  = "access_token"
+	RefreshTokenType = "refresh_token"
+)
+
+var (
+	ErrInvalidClient = errors.New("invalid client")
+	ErrInvalidGrant  = errors.New("invalid grant")
+	ErrInvalidTarget = errors.New("invalid target")
+)
+
+type OAuth interface {
+	AuthenticateClient(clientId, clientSecret string) (*repository.Client, error)
+	Introspect(token, tokenTypeHint string) *Introspection
+	Revoke(client *repository.Client, token, tokenTypeHint string) error
+	RevokeToken(token string) (bool, error)
+	ExchangeToken(client *repository.Client, exchange *TokenExchange) (string, *jwt.Claims, error)
+}
+
+// Introspection describes a token the way RFC 7662 does. Only Active is set
+// for tokens that are not valid.
+type Introspection struct {
+	Active    bool
+	TokenType string
+	Claims    *jwt.Claims
+	Session   *repository.Session
+}
+
+// TokenExchange is a token exchange request (RFC 8693). Without an actor
+// token the client impersonates the subject; with one the new token is
+// delegated to the actor and records it in the "act" claim.
+type TokenExchange struct {
+	SubjectToken string
+	ActorToken   string
+	Audience     []string
+	Scope        string
+	Confirmation *jwt.Confirmation
+}
+
+type oauth struct {
+	clientRepository repository.ClientRepository
+	authService      Auth
+	jwtMaker         jwt.Maker
+	maxTokenSize     int
+}
+
+func NewOAuthService(clientRepository repository.ClientRepository, authService Auth, jwtMaker jwt.Maker, maxTokenSize int) *oauth {
+	return &oauth{
+		clientRepository: clientRepository,
+		authService:      authService,
+		jwtMaker:         jwtMaker,
+		maxTokenSize:     maxTokenSize,
+	}
+}
+
+// AuthenticateClient authenticates a confidential client by its secret, and
+// identifies a public client, which has none, by its id alone.
+func (s *oauth) AuthenticateClient(clientId, clientSecret string) (*repository.Client, error) {
+	if clientId == "" {
+		return nil, ErrInvalidClient
+	}
+
+	client, err := s.clientRepository.GetClientById(clientId)
+	if err != nil {
+		return nil, ErrInvalidClient
+	}
+	if !client.IsConfidential() {
+		if clientSecret != "" {
+			return nil, ErrInvalidClient
+		}
+		return client, nil
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(*client.SecretHash), []byte(clientSecret))
+	if err != nil {
+		return nil, ErrInvalidClient
+	}
+	return client, nil
+}
+
+func (s *oauth) Introspect(token, tokenTypeHint string) *Introspection {
+	introspectors := []func(string) *Introspection{s.introspectAccessToken, s.introspectRefreshToken}
+	if tokenTypeHint == RefreshTokenType {
+		introspectors[0], introspectors[1] = introspectors[1], introspectors[0]
+	}
+
+	for _, introspect := range introspectors {
+		if introspection := introspect(token); introspection != nil {
+			return introspection
+		}
+	}
+	return &Introspection{Active: false}
+}
+
+// Revoke revokes a token issued to the client. As RFC 7009 requires, tokens
+// that are invalid, already revoked or issued to another client are ignored.
+func (s *oauth) Revoke(client *repository.Client, token, tokenTypeHint string) error {
+	introspection := s.Introspect(token, tokenTypeHint)
+	switch {
+	case !introspection.Active:
+		return nil
+	case introspection.Claims != nil && introspection.Claims.ClientId == client.Id:
+		return s.authService.RevokeAccessToken(introspection.Claims)
+	case introspection.Session != nil && introspection.Session.ClientId == client.Id:
+		return s.authService.RevokeSession(introspection.Session.Id)
+	default:
+		return nil
+	}
+}
+
+// RevokeToken revokes an access or a refresh token whichever client it was
+// issued to, and reports whether it was active.
+func (s *oauth) RevokeToken(token string) (bool, error) {
+	introspection := s.Introspect(token, "")
+	switch {
+	case !introspection.Active:
+		return false, nil
+	case introspection.Claims != nil:
+		return true, s.authService.RevokeAccessToken(introspection.Claims)
+	default:
+		return true, s.authService.RevokeSession(introspection.Session.Id)
+	}
+}
+
+// ExchangeToken trades an access token issued to the client for a token
+// aimed at one of the audiences the client is registered for. The new token
+// never gets more than the subject token had: its scope is narrowed down to
+// the requested one, it expires no later and it belongs to the same session,
+// so that it is revoked together with it. Roles and permissions are not
+// carried over, the new token only grants its scope. The actor token must
+// be issued to or for the client as well. A subject or actor token bound to
+// a key can only be exchanged by proving possession of the same key, and
+// the new token is bound to it, too.
+func (s *oauth) ExchangeToken(client *repository.Client, exchange *TokenExchange) (string, *jwt.Claims, error) {
+	subject, err := s.authenticateFor(client, exchange.SubjectToken, exchange.Confirmation)
+	if err != nil {
+		return "", nil, err
+	}
+
+	audience := jwt.Audience(exchange.Audience)
+	if len(audience) == 0 {
+		audience = subject.Audience
+	}
+	for _, aud := range audience {
+		if !jwt.Audience(client.Audiences).Contains(aud) && !subject.Audience.Contains(aud) {
+			return "", nil, ErrInvalidTarget
+		}
+	}
+
+	actor := subject.Actor
+	if exchange.ActorToken != "" {
+		actorClaims, err := s.authenticateFor(client, exchange.ActorToken, exchange.Confirmation)
+		if err != nil {
+			return "", nil, err
+		}
+		actor = &jwt.Actor{
+			Subject:  strconv.Itoa(actorClaims.Subject),
+			ClientId: actorClaims.ClientId,
+			Actor:    subject.Actor,
+		}
+	}
+
+	claims := &jwt.Claims{
+		Subject:      subject.Subject,
+		Email:        subject.Email,
+		ExpiresAt:    subject.ExpiresAt,
+		Audience:     audience,
+		ClientId:     client.Id,
+		SessionId:    subject.SessionId,
+		Scope:        grantScope(exchange.Scope, subject.Scopes()),
+		Confirmation: exchange.Confirmation,
+		Actor:        actor,
+		Extra:        subject.Extra,
+	}
+	accessToken, err := s.jwtMaker.GenerateAccessToken(claims)
+	if err != nil {
+		return "", nil, errors.New("cannot generate access token")
+	}
+	if s.maxTokenSize > 0 && len(accessToken) > s.maxTokenSize {
+		log.Printf("exchanged access token of user %d is %d bytes long, the limit is %d", subject.Subject, len(accessToken), s.maxTokenSize)
+		return "", nil, errors.New("access token is too large")
+	}
+	return accessToken, claims, nil
+}
+
+// authenticateFor authenticates an access token exchanged by the client. The
+// token must be issued to or for the client, and the client must prove
+// possession of the key it is bound to.
+func (s *oauth) authenticateFor(client *repository.Client, token string, cnf *jwt.Confirmation) (*jwt.Claims, error) {
+	claims, err := s.authService.Authenticate(token, "")
+	if err != nil {
+		return nil, ErrInvalidGrant
+	}
+	if claims.ClientId != client.Id && !claims.Audience.Contains(client.Id) {
+		return nil, ErrInvalidGrant
+	}
+	if !holdsConfirmation(cnf, claims.Confirmation) {
+		return nil, ErrInvalidGrant
+	}
+	return claims, nil
+}
+
+// holdsConfirmation tells whether the keys proven with a request include
+// every key the token is bound to.
+func holdsConfirmation(proven, bound *jwt.Confirmation) bool {
+	if bound == nil {
+		return true
+	}
+	if proven == nil {
+		proven = &jwt.Confirmation{}
+	}
+	if bound.JwkThumbprint != "" && proven.JwkThumbprint != bound.JwkThumbprint {
+		return false
+	}
+	if bound.CertificateThumbprint != "" && proven.CertificateThumbprint != bound.CertificateThumbprint {
+		return false
+	}
+	return true
+}
+
+func (s *oauth) introspectAccessToken(token string) *Introspection {
+	claims, err := s.authService.Authenticate(token, "")
+	if err != nil {
+		return nil
+	}
+	return &Introspection{
+		Active:    true,
+		TokenType: AccessTokenType,
+		Claims:    claims,
+	}
+}
+
+func (s *oauth) introspectRefreshToken(token string) *Introspection {
+	session, err := s.authService.VerifyRefreshToken(token)
+	if err != nil {
+		return nil
+	}
+	return &Introspection{
+		Active:    true,
+		TokenType: RefreshTokenType,
+		Session:   session,
+	}
+}
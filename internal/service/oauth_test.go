@@ -0,0 +1,238 @@
+package service
+
+import (
+	"errors"
+	"github.com/evleria/jwt-auth-demo/internal/jwt"
+	"github.com/evleria/jwt-auth-demo/internal/repository"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// stubAuth authenticates the access tokens it knows the claims of.
+type stubAuth struct {
+	Auth
+	tokens map[string]*jwt.Claims
+}
+
+func (s *stubAuth) Authenticate(accessToken string, audience string) (*jwt.Claims, error) {
+	claims, ok := s.tokens[accessToken]
+	if !ok {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+func TestExchangeToken(t *testing.T) {
+	jwtMaker, err := jwt.NewMakerFromConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiresAt := time.Now().Add(time.Minute).Unix()
+	dpopKey := &jwt.Confirmation{JwkThumbprint: "dpop-key"}
+	s := NewOAuthService(nil, &stubAuth{tokens: map[string]*jwt.Claims{
+		"subject": {
+			Subject:     1,
+			ExpiresAt:   expiresAt,
+			Audience:    jwt.Audience{"jwt-auth-demo", "orders"},
+			ClientId:    "gateway",
+			SessionId:   "session",
+			Scope:       "orders:read orders:write",
+			Roles:       []string{"admin"},
+			Permissions: []string{"users:write"},
+		},
+		"subject-for-gateway": {Subject: 1, ExpiresAt: expiresAt, Audience: jwt.Audience{"gateway"}, ClientId: "spa"},
+		"subject-of-spa":      {Subject: 1, ExpiresAt: expiresAt, Audience: jwt.Audience{"jwt-auth-demo"}, ClientId: "spa"},
+		"subject-with-dpop":   {Subject: 1, ExpiresAt: expiresAt, Audience: jwt.Audience{"jwt-auth-demo"}, ClientId: "gateway", Confirmation: dpopKey},
+		"delegated-subject": {
+			Subject:   1,
+			ExpiresAt: expiresAt,
+			Audience:  jwt.Audience{"jwt-auth-demo"},
+			ClientId:  "gateway",
+			Actor:     &jwt.Actor{Subject: "2", ClientId: "gateway"},
+		},
+		"actor":           {Subject: 3, ExpiresAt: expiresAt, ClientId: "gateway"},
+		"actor-of-spa":    {Subject: 3, ExpiresAt: expiresAt, ClientId: "spa"},
+		"actor-with-dpop": {Subject: 3, ExpiresAt: expiresAt, ClientId: "gateway", Confirmation: dpopKey},
+	}}, jwtMaker, 0)
+	client := &repository.Client{Id: "gateway", Audiences: []string{"billing"}}
+
+	tests := []struct {
+		name     string
+		exchange TokenExchange
+		err      error
+		audience jwt.Audience
+		scope    string
+		actor    *jwt.Actor
+		cnf      *jwt.Confirmation
+	}{
+		{
+			name:     "impersonation keeps the audience and scope",
+			exchange: TokenExchange{SubjectToken: "subject"},
+			audience: jwt.Audience{"jwt-auth-demo", "orders"},
+			scope:    "orders:read orders:write",
+		},
+		{
+			name:     "audience of the client and narrowed scope",
+			exchange: TokenExchange{SubjectToken: "subject", Audience: []string{"billing"}, Scope: "orders:read admin"},
+			audience: jwt.Audience{"billing"},
+			scope:    "orders:read",
+		},
+		{
+			name:     "audience neither of the client nor of the subject",
+			exchange: TokenExchange{SubjectToken: "subject", Audience: []string{"payroll"}},
+			err:      ErrInvalidTarget,
+		},
+		{name: "invalid subject token", exchange: TokenExchange{SubjectToken: "forged"}, err: ErrInvalidGrant},
+		{
+			name:     "subject token issued for the client",
+			exchange: TokenExchange{SubjectToken: "subject-for-gateway"},
+			audience: jwt.Audience{"gateway"},
+		},
+		{name: "subject token of another client", exchange: TokenExchange{SubjectToken: "subject-of-spa"}, err: ErrInvalidGrant},
+		{
+			name:     "delegation records the actor",
+			exchange: TokenExchange{SubjectToken: "subject", ActorToken: "actor"},
+			audience: jwt.Audience{"jwt-auth-demo", "orders"},
+			scope:    "orders:read orders:write",
+			actor:    &jwt.Actor{Subject: "3", ClientId: "gateway"},
+		},
+		{
+			name:     "delegation chains the previous actor",
+			exchange: TokenExchange{SubjectToken: "delegated-subject", ActorToken: "actor"},
+			audience: jwt.Audience{"jwt-auth-demo"},
+			actor:    &jwt.Actor{Subject: "3", ClientId: "gateway", Actor: &jwt.Actor{Subject: "2", ClientId: "gateway"}},
+		},
+		{
+			name:     "impersonation keeps the previous actor",
+			exchange: TokenExchange{SubjectToken: "delegated-subject"},
+			audience: jwt.Audience{"jwt-auth-demo"},
+			actor:    &jwt.Actor{Subject: "2", ClientId: "gateway"},
+		},
+		{name: "invalid actor token", exchange: TokenExchange{SubjectToken: "subject", ActorToken: "forged"}, err: ErrInvalidGrant},
+		{name: "actor token of another client", exchange: TokenExchange{SubjectToken: "subject", ActorToken: "actor-of-spa"}, err: ErrInvalidGrant},
+		{name: "bound subject token without proof", exchange: TokenExchange{SubjectToken: "subject-with-dpop"}, err: ErrInvalidGrant},
+		{
+			name:     "bound subject token with a proof of another key",
+			exchange: TokenExchange{SubjectToken: "subject-with-dpop", Confirmation: &jwt.Confirmation{JwkThumbprint: "other-key"}},
+			err:      ErrInvalidGrant,
+		},
+		{
+			name:     "bound subject token with a proof of its key",
+			exchange: TokenExchange{SubjectToken: "subject-with-dpop", Confirmation: dpopKey},
+			audience: jwt.Audience{"jwt-auth-demo"},
+			cnf:      dpopKey,
+		},
+		{name: "bound actor token without proof", exchange: TokenExchange{SubjectToken: "subject", ActorToken: "actor-with-dpop"}, err: ErrInvalidGrant},
+		{
+			name:     "bound actor token with a proof of its key",
+			exchange: TokenExchange{SubjectToken: "subject", ActorToken: "actor-with-dpop", Confirmation: dpopKey},
+			audience: jwt.Audience{"jwt-auth-demo", "orders"},
+			scope:    "orders:read orders:write",
+			actor:    &jwt.Actor{Subject: "3", ClientId: "gateway"},
+			cnf:      dpopKey,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			token, claims, err := s.ExchangeToken(client, &test.exchange)
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("got error %v, want %v", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error %v, want none", err)
+			}
+			if token == "" {
+				t.Fatal("got no token")
+			}
+			if claims.Subject != 1 || claims.ClientId != client.Id || claims.ExpiresAt != expiresAt {
+				t.Fatalf("got subject %d of client %q expiring at %d", claims.Subject, claims.ClientId, claims.ExpiresAt)
+			}
+			if !reflect.DeepEqual(claims.Audience, test.audience) {
+				t.Fatalf("got audience %v, want %v", claims.Audience, test.audience)
+			}
+			if claims.Scope != test.scope {
+				t.Fatalf("got scope %q, want %q", claims.Scope, test.scope)
+			}
+			if claims.Roles != nil || claims.Permissions != nil {
+				t.Fatalf("got roles %v and permissions %v, want none", claims.Roles, claims.Permissions)
+			}
+			if !reflect.DeepEqual(claims.Actor, test.actor) {
+				t.Fatalf("got actor %+v, want %+v", claims.Actor, test.actor)
+			}
+			if !reflect.DeepEqual(claims.Confirmation, test.cnf) {
+				t.Fatalf("got confirmation %+v, want %+v", claims.Confirmation, test.cnf)
+			}
+		})
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	tests := []struct {
+		name          string
+		client        string
+		refresh       bool
+		tokenTypeHint string
+		revoked       bool
+	}{
+		{name: "own access token", client: "spa", revoked: true},
+		{name: "own access token with a refresh token hint", client: "spa", tokenTypeHint: RefreshTokenType, revoked: true},
+		{name: "access token of another client", client: "cli"},
+		{name: "own refresh token", client: "spa", refresh: true, revoked: true},
+		{name: "own refresh token with an access token hint", client: "spa", refresh: true, tokenTypeHint: AccessTokenType, revoked: true},
+		{name: "refresh token of another client", client: "cli", refresh: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			authService, repositories := newAuthTestService(t, NewJwtRefreshTokens)
+			repositories.clients["spa"] = &repository.Client{Id: "spa"}
+			repositories.clients["cli"] = &repository.Client{Id: "cli"}
+			s := NewOAuthService(repositories.clients, authService, authService.jwtMaker, 0)
+			accessToken, refreshToken, err := authService.Login(authTestEmail, authTestPassword, "spa", "", "test", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			token := accessToken
+			if test.refresh {
+				token = refreshToken
+			}
+			if err := s.Revoke(repositories.clients[test.client], token, test.tokenTypeHint); err != nil {
+				t.Fatalf("got error %v, want none", err)
+			}
+
+			active := s.Introspect(token, "").Active
+			if active == test.revoked {
+				t.Fatalf("got active %t, want %t", active, !test.revoked)
+			}
+			if _, err := authService.Authenticate(accessToken, ""); test.refresh && test.revoked && err == nil {
+				t.Fatal("access token of a revoked session has been accepted")
+			}
+		})
+	}
+}
+
+func TestRevokeIgnoresInactiveTokens(t *testing.T) {
+	authService, repositories := newAuthTestService(t, NewJwtRefreshTokens)
+	client := &repository.Client{Id: "spa"}
+	repositories.clients[client.Id] = client
+	s := NewOAuthService(repositories.clients, authService, authService.jwtMaker, 0)
+	accessToken, _, err := authService.Login(authTestEmail, authTestPassword, client.Id, "", "test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, token := range []string{"not-a-token", accessToken, accessToken} {
+		if err := s.Revoke(client, token, ""); err != nil {
+			t.Fatalf("got error %v, want none", err)
+		}
+	}
+	if active, err := s.RevokeToken(accessToken); err != nil || active {
+		t.Fatalf("got active %t and error %v for a revoked token, want neither", active, err)
+	}
+}
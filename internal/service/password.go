@@ -0,0 +1,122 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/evleria/jwt-auth-demo/internal/config"
+	"github.com/evleria/jwt-auth-demo/internal/mail"
+	"github.com/evleria/jwt-auth-demo/internal/repository"
+	"log"
+	"net/url"
+	"time"
+)
+
+var ErrInvalidResetToken = errors.New("invalid or expired reset token")
+
+// PasswordReset lets users who forgot their password set a new one with a
+// token mailed to them.
+type PasswordReset interface {
+	ForgotPassword(email string) error
+	ResetPassword(token, password string) error
+}
+
+type passwordReset struct {
+	userRepository  repository.UserRepository
+	resetRepository repository.PasswordResetRepository
+	authService     Auth
+	mailSender      mail.Sender
+	tokenLifetime   time.Duration
+	resetUrl        *url.URL
+}
+
+// NewPasswordResetServiceFromConfig reads PASSWORD_RESET_TOKEN_LIFETIME and
+// PASSWORD_RESET_URL, the page of the frontend the mailed link points to.
+// The token is added to the query of the link.
+func NewPasswordResetServiceFromConfig(userRepository repository.UserRepository, resetRepository repository.PasswordResetRepository, authService Auth, mailSender mail.Sender) (*passwordReset, error) {
+	service := &passwordReset{
+		userRepository:  userRepository,
+		resetRepository: resetRepository,
+		authService:     authService,
+		mailSender:      mailSender,
+		tokenLifetime:   config.GetDuration("PASSWORD_RESET_TOKEN_LIFETIME", time.Minute*15),
+	}
+
+	if resetUrl := config.GetString("PASSWORD_RESET_URL", ""); resetUrl != "" {
+		u, err := url.Parse(resetUrl)
+		if err != nil || !u.IsAbs() {
+			return nil, errors.New("PASSWORD_RESET_URL must be an absolute URL")
+		}
+		service.resetUrl = u
+	}
+	return service, nil
+}
+
+// ForgotPassword mails a reset token to the user. Unknown and disabled
+// users get nothing, and the mail is sent in the background, so that
+// callers cannot tell whether an email is registered.
+func (s *passwordReset) ForgotPassword(email string) error {
+	user, err := s.userRepository.GetUserByEmail(email)
+	if err != nil || user.Disabled {
+		return nil
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return errors.New("cannot generate reset token")
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret)
+
+	err = s.resetRepository.CreateResetToken(user.Id, hashResetToken(token), s.tokenLifetime)
+	if err != nil {
+		return errors.New("cannot create reset token")
+	}
+
+	message := &mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body:    s.resetMailBody(user, token),
+	}
+	go func() {
+		if err := s.mailSender.Send(message); err != nil {
+			log.Printf("cannot send password reset mail to user %d: %v", user.Id, err)
+		}
+	}()
+	return nil
+}
+
+// ResetPassword sets the password of the user the token was issued to and
+// revokes every session of the user. The token cannot be used again.
+func (s *passwordReset) ResetPassword(token, password string) error {
+	userId, ok, err := s.resetRepository.UseResetToken(hashResetToken(token))
+	if err != nil {
+		return errors.New("cannot reset password")
+	}
+	if !ok {
+		return ErrInvalidResetToken
+	}
+	return s.authService.SetPassword(userId, password)
+}
+
+func (s *passwordReset) resetMailBody(user *repository.User, token string) string {
+	instructions := fmt.Sprintf("use this token to reset your password:\r\n\r\n%s", token)
+	if s.resetUrl != nil {
+		link := *s.resetUrl
+		query := link.Query()
+		query.Set("token", token)
+		link.RawQuery = query.Encode()
+		instructions = fmt.Sprintf("follow this link to reset your password:\r\n\r\n%s", link.String())
+	}
+	return fmt.Sprintf("Hi %s,\r\n\r\nsomeone asked to reset the password of your account. If it was you, %s\r\n\r\n"+
+		"It expires in %s. If it was not you, you can ignore this mail.\r\n",
+		user.FirstName, instructions, s.tokenLifetime)
+}
+
+// Reset tokens are stored hashed, so that a leaked store does not give away
+// usable tokens. They are random enough for a plain hash.
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,203 @@
+package service
+
+import (
+	"errors"
+	"github.com/evleria/jwt-auth-demo/internal/mail"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type resetToken struct {
+	userId    int
+	expiresAt time.Time
+}
+
+type memoryResetRepository struct {
+	mu     sync.Mutex
+	tokens map[string]resetToken
+	users  map[int]string
+}
+
+func newMemoryResetRepository() *memoryResetRepository {
+	return &memoryResetRepository{
+		tokens: make(map[string]resetToken),
+		users:  make(map[int]string),
+	}
+}
+
+func (r *memoryResetRepository) CreateResetToken(userId int, tokenHash string, ttl time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.tokens, r.users[userId])
+	r.tokens[tokenHash] = resetToken{userId: userId, expiresAt: time.Now().Add(ttl)}
+	r.users[userId] = tokenHash
+	return nil
+}
+
+func (r *memoryResetRepository) UseResetToken(tokenHash string) (int, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	token, ok := r.tokens[tokenHash]
+	delete(r.tokens, tokenHash)
+	if !ok || !time.Now().Before(token.expiresAt) {
+		return 0, false, nil
+	}
+	delete(r.users, token.userId)
+	return token.userId, true, nil
+}
+
+// memorySender hands the messages it sends over to the test.
+type memorySender chan *mail.Message
+
+func (s memorySender) Send(message *mail.Message) error {
+	s <- message
+	return nil
+}
+
+// receiveResetToken waits for a reset mail and picks the token from the link
+// in it.
+func receiveResetToken(t *testing.T, sender memorySender) string {
+	t.Helper()
+	select {
+	case message := <-sender:
+		if message.To != authTestEmail {
+			t.Fatalf("got a mail to %s, want %s", message.To, authTestEmail)
+		}
+		start := strings.Index(message.Body, "https://")
+		if start < 0 {
+			t.Fatalf("got no link in %q", message.Body)
+		}
+		link, err := url.Parse(strings.Fields(message.Body[start:])[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if link.Query().Get("lang") != "en" {
+			t.Fatalf("got link %s without the query of the reset url", link)
+		}
+		return link.Query().Get("token")
+	case <-time.After(time.Second):
+		t.Fatal("got no reset mail")
+		return ""
+	}
+}
+
+func newPasswordResetTestService(t *testing.T, tokenLifetime time.Duration) (*passwordReset, *auth, *authTestRepositories, memorySender) {
+	authService, repositories := newAuthTestService(t, NewJwtRefreshTokens)
+	resetUrl, err := url.Parse("https://app.example.com/reset?lang=en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := make(memorySender, 4)
+	s := &passwordReset{
+		userRepository:  repositories.users,
+		resetRepository: newMemoryResetRepository(),
+		authService:     authService,
+		mailSender:      sender,
+		tokenLifetime:   tokenLifetime,
+		resetUrl:        resetUrl,
+	}
+	return s, authService, repositories, sender
+}
+
+func TestResetPassword(t *testing.T) {
+	s, authService, _, sender := newPasswordResetTestService(t, time.Minute)
+	_, refreshToken, err := authService.Login(authTestEmail, authTestPassword, "", "", "test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.ForgotPassword(authTestEmail); err != nil {
+		t.Fatal(err)
+	}
+	token := receiveResetToken(t, sender)
+	if err := s.ResetPassword(token, "new-password"); err != nil {
+		t.Fatalf("got error %v, want none", err)
+	}
+
+	if _, _, err := authService.Login(authTestEmail, authTestPassword, "", "", "test", nil); err == nil {
+		t.Fatal("old password is still accepted")
+	}
+	if _, _, err := authService.Login(authTestEmail, "new-password", "", "", "test", nil); err != nil {
+		t.Fatalf("got error %v for the new password, want none", err)
+	}
+	if _, _, err := authService.Refresh(refreshToken, nil); err == nil {
+		t.Fatal("session started before the reset is still alive")
+	}
+	if err := s.ResetPassword(token, "another-password"); !errors.Is(err, ErrInvalidResetToken) {
+		t.Fatalf("got error %v for a used token, want %v", err, ErrInvalidResetToken)
+	}
+}
+
+func TestResetPasswordRejectsTokens(t *testing.T) {
+	tests := []struct {
+		name          string
+		tokenLifetime time.Duration
+		token         func(t *testing.T, s *passwordReset, sender memorySender) string
+	}{
+		{
+			name:          "unknown token",
+			tokenLifetime: time.Minute,
+			token: func(t *testing.T, s *passwordReset, sender memorySender) string {
+				return "unknown"
+			},
+		},
+		{
+			name:          "expired token",
+			tokenLifetime: time.Nanosecond,
+			token: func(t *testing.T, s *passwordReset, sender memorySender) string {
+				if err := s.ForgotPassword(authTestEmail); err != nil {
+					t.Fatal(err)
+				}
+				return receiveResetToken(t, sender)
+			},
+		},
+		{
+			name:          "token replaced by a newer one",
+			tokenLifetime: time.Minute,
+			token: func(t *testing.T, s *passwordReset, sender memorySender) string {
+				if err := s.ForgotPassword(authTestEmail); err != nil {
+					t.Fatal(err)
+				}
+				first := receiveResetToken(t, sender)
+				if err := s.ForgotPassword(authTestEmail); err != nil {
+					t.Fatal(err)
+				}
+				receiveResetToken(t, sender)
+				return first
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, authService, _, sender := newPasswordResetTestService(t, test.tokenLifetime)
+
+			err := s.ResetPassword(test.token(t, s, sender), "new-password")
+			if !errors.Is(err, ErrInvalidResetToken) {
+				t.Fatalf("got error %v, want %v", err, ErrInvalidResetToken)
+			}
+			if _, _, err := authService.Login(authTestEmail, authTestPassword, "", "", "test", nil); err != nil {
+				t.Fatalf("got error %v for the unchanged password, want none", err)
+			}
+		})
+	}
+}
+
+func TestForgotPasswordMailsOnlyActiveUsers(t *testing.T) {
+	s, _, repositories, sender := newPasswordResetTestService(t, time.Minute)
+	repositories.users[1].Disabled = true
+
+	for _, email := range []string{"nobody@example.com", authTestEmail} {
+		if err := s.ForgotPassword(email); err != nil {
+			t.Fatalf("got error %v for %s, want none", err, email)
+		}
+	}
+	select {
+	case message := <-sender:
+		t.Fatalf("got a mail to %s", message.To)
+	case <-time.After(time.Millisecond * 50):
+	}
+}
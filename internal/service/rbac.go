@@ -0,0 +1,97 @@
+package service
+
+import (
+	"errors"
+	"github.com/evleria/jwt-auth-demo/internal/repository"
+)
+
+var ErrAlreadyExists = errors.New("already exists")
+
+// RBAC manages roles and their assignments. Access tokens carry the roles
+// and permissions of the user at the time they are issued, so changes take
+// effect on the next refresh.
+type RBAC interface {
+	GetRoles() ([]*repository.Role, error)
+	CreateRole(name string, permissions []string) error
+	GetUserRoles(userId int) ([]string, error)
+	AssignRole(userId int, role string) error
+	UnassignRole(userId int, role string) error
+}
+
+type rbac struct {
+	userRepository repository.UserRepository
+	roleRepository repository.RoleRepository
+}
+
+func NewRBACService(userRepository repository.UserRepository, roleRepository repository.RoleRepository) *rbac {
+	return &rbac{
+		userRepository: userRepository,
+		roleRepository: roleRepository,
+	}
+}
+
+func (s *rbac) GetRoles() ([]*repository.Role, error) {
+	roles, err := s.roleRepository.GetRoles()
+	if err != nil {
+		return nil, errors.New("cannot load roles")
+	}
+	return roles, nil
+}
+
+func (s *rbac) CreateRole(name string, permissions []string) error {
+	err := s.roleRepository.CreateRole(name, permissions)
+	if errors.Is(err, repository.ErrRoleExists) {
+		return ErrAlreadyExists
+	}
+	if err != nil {
+		return errors.New("cannot create role")
+	}
+	return nil
+}
+
+func (s *rbac) GetUserRoles(userId int) ([]string, error) {
+	if err := s.checkUserExists(userId); err != nil {
+		return nil, err
+	}
+	roles, err := s.roleRepository.GetUserRoles(userId)
+	if err != nil {
+		return nil, errors.New("cannot load roles")
+	}
+	return roles, nil
+}
+
+func (s *rbac) AssignRole(userId int, role string) error {
+	if err := s.checkUserExists(userId); err != nil {
+		return err
+	}
+	err := s.roleRepository.AssignRole(userId, role)
+	if errors.Is(err, repository.ErrRoleNotFound) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return errors.New("cannot assign role")
+	}
+	return nil
+}
+
+func (s *rbac) UnassignRole(userId int, role string) error {
+	if err := s.checkUserExists(userId); err != nil {
+		return err
+	}
+	err := s.roleRepository.UnassignRole(userId, role)
+	if errors.Is(err, repository.ErrRoleNotFound) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return errors.New("cannot unassign role")
+	}
+	return nil
+}
+
+func (s *rbac) checkUserExists(userId int) error {
+	_, err := s.userRepository.GetUserById(userId)
+	if err != nil {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/evleria/jwt-auth-demo/internal/config"
+	"github.com/evleria/jwt-auth-demo/internal/jwt"
+	"github.com/evleria/jwt-auth-demo/internal/repository"
+	"strings"
+	"time"
+)
+
+// RefreshTokens issues the refresh tokens of a session in a single format.
+// The returned token id is what the session stores to recognize the latest
+// token of its family. Tokens expire at the given time at the latest.
+type RefreshTokens interface {
+	Generate(session *repository.Session, expiresAt time.Time) (token string, tokenId string, actualExpiresAt time.Time, err error)
+	Parse(refreshToken string) (sessionId string, tokenId string, err error)
+}
+
+func NewRefreshTokensFromConfig(jwtMaker jwt.Maker) (RefreshTokens, error) {
+	switch format := config.GetString("REFRESH_TOKEN_FORMAT", "jwt"); format {
+	case "jwt":
+		return NewJwtRefreshTokens(jwtMaker), nil
+	case "opaque":
+		return NewOpaqueRefreshTokens(), nil
+	default:
+		return nil, fmt.Errorf("unsupported refresh token format %q", format)
+	}
+}
+
+type jwtRefreshTokens struct {
+	jwtMaker jwt.Maker
+}
+
+func NewJwtRefreshTokens(jwtMaker jwt.Maker) RefreshTokens {
+	return &jwtRefreshTokens{
+		jwtMaker: jwtMaker,
+	}
+}
+
+// Generate issues a JWT refresh token, which never lives longer than
+// REFRESH_TOKEN_DURATION so that it outlives no signing key.
+func (r *jwtRefreshTokens) Generate(session *repository.Session, expiresAt time.Time) (string, string, time.Time, error) {
+	claims := &jwt.Claims{
+		Subject:   session.UserId,
+		ClientId:  session.ClientId,
+		SessionId: session.Id,
+		ExpiresAt: expiresAt.Unix(),
+	}
+	token, err := r.jwtMaker.GenerateRefreshToken(claims)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	return token, claims.Id, claims.ExpiresAtTime(), nil
+}
+
+func (r *jwtRefreshTokens) Parse(refreshToken string) (string, string, error) {
+	claims, err := r.jwtMaker.VerifyRefreshToken(refreshToken)
+	if err != nil {
+		return "", "", err
+	}
+	if claims.SessionId == "" {
+		return "", "", errors.New("token has no session")
+	}
+	return claims.SessionId, claims.Id, nil
+}
+
+// opaqueRefreshTokens issues random handles prefixed with their session id.
+// Only a hash of the handle is stored, so a leaked store cannot be used to
+// refresh.
+type opaqueRefreshTokens struct{}
+
+func NewOpaqueRefreshTokens() RefreshTokens {
+	return &opaqueRefreshTokens{}
+}
+
+func (r *opaqueRefreshTokens) Generate(session *repository.Session, expiresAt time.Time) (string, string, time.Time, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", time.Time{}, err
+	}
+	token := session.Id + "." + base64.RawURLEncoding.EncodeToString(secret)
+	return token, hashToken(token), expiresAt, nil
+}
+
+func (r *opaqueRefreshTokens) Parse(refreshToken string) (string, string, error) {
+	parts := strings.Split(refreshToken, ".")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", errors.New("malformed refresh token")
+	}
+	return parts[0], hashToken(refreshToken), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
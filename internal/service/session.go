@@ -0,0 +1,59 @@
+package service
+
+import (
+	"errors"
+	"github.com/evleria/jwt-auth-demo/internal/config"
+	"github.com/evleria/jwt-auth-demo/internal/repository"
+	"time"
+)
+
+// SessionPolicy limits how long sessions live: a session ends once it has
+// not been refreshed for IdleTimeout, and MaxLifetime after the login at the
+// latest. Clients can override both limits.
+type SessionPolicy struct {
+	IdleTimeout time.Duration
+	MaxLifetime time.Duration
+}
+
+// NewSessionPolicyFromConfig reads SESSION_IDLE_TIMEOUT, which defaults to
+// REFRESH_TOKEN_DURATION, and SESSION_MAX_LIFETIME.
+func NewSessionPolicyFromConfig() SessionPolicy {
+	return SessionPolicy{
+		IdleTimeout: config.GetDuration("SESSION_IDLE_TIMEOUT", config.GetDuration("REFRESH_TOKEN_DURATION", time.Hour*24*7)),
+		MaxLifetime: config.GetDuration("SESSION_MAX_LIFETIME", time.Hour*24*30),
+	}
+}
+
+func (p SessionPolicy) forClient(client *repository.Client) SessionPolicy {
+	if client == nil {
+		return p
+	}
+	if client.SessionIdleTimeout != nil {
+		p.IdleTimeout = time.Duration(*client.SessionIdleTimeout) * time.Second
+	}
+	if client.SessionMaxLifetime != nil {
+		p.MaxLifetime = time.Duration(*client.SessionMaxLifetime) * time.Second
+	}
+	return p
+}
+
+// check tells whether the session is still alive at the time.
+func (p SessionPolicy) check(session *repository.Session, now time.Time) error {
+	if !now.Before(session.ExpiresAt) {
+		return errors.New("session has expired")
+	}
+	if now.Sub(session.LastUsedAt) > p.IdleTimeout {
+		return errors.New("session has been idle for too long")
+	}
+	return nil
+}
+
+// refreshTokenExpiry is when a refresh token issued at the time expires: once
+// the session is idle for too long, or when the session ends.
+func (p SessionPolicy) refreshTokenExpiry(session *repository.Session, now time.Time) time.Time {
+	expiresAt := now.Add(p.IdleTimeout)
+	if session.ExpiresAt.Before(expiresAt) {
+		return session.ExpiresAt
+	}
+	return expiresAt
+}
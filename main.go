@@ -2,12 +2,15 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
 	_ "github.com/evleria/jwt-auth-demo/docs"
 	"github.com/evleria/jwt-auth-demo/internal/config"
 	"github.com/evleria/jwt-auth-demo/internal/handler"
 	"github.com/evleria/jwt-auth-demo/internal/jwt"
+	"github.com/evleria/jwt-auth-demo/internal/mail"
 	"github.com/evleria/jwt-auth-demo/internal/repository"
 	"github.com/evleria/jwt-auth-demo/internal/service"
 	"github.com/go-redis/redis/v8"
@@ -16,16 +19,28 @@ import (
 	"github.com/labstack/echo/v4/middleware"
 	echoSwagger "github.com/swaggo/echo-swagger"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
 )
 
 var cfgPath string
 
 func initFlags() {
 	flag.StringVar(&cfgPath, "config", "./configs/.env", "The application configuration")
+	flag.Usage = printUsage
 	flag.Parse()
 }
 
 // @title JWT Auth Demo Project
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+// @securityDefinitions.basic BasicAuth
 func main() {
 	initFlags()
 
@@ -34,30 +49,102 @@ func main() {
 		log.Println(err)
 	}
 
-	dbUrl := getPostgresConnectionString()
-	db, err := pgx.Connect(context.Background(), dbUrl)
-	check(err)
+	check(runCommand(flag.Args()))
+}
 
-	redisClient := redis.NewClient(&redis.Options{
-		Addr:     getRedisAddress(),
-		Password: config.GetString("REDIS_PASSWORD", ""),
-	})
-	_, err = redisClient.Ping(context.TODO()).Result()
-	check(err)
+func serve() error {
+	db, err := connectPostgres()
+	if err != nil {
+		return err
+	}
+	redisClient, err := connectRedis()
+	if err != nil {
+		return err
+	}
+
+	jwtMaker, err := jwt.NewMakerFromConfig()
+	if err != nil {
+		return err
+	}
+	go rotateKeysOnSignal(jwtMaker)
+
+	tlsConfig, err := getTLSConfig()
+	if err != nil {
+		return err
+	}
+
+	services, err := newServices(db, redisClient, jwtMaker)
+	if err != nil {
+		return err
+	}
 
 	e := echo.New()
-	initRoutes(e, db, redisClient)
+	initRoutes(e, services, jwtMaker, tlsConfig != nil && tlsConfig.ClientCAs != nil)
 
-	port := fmt.Sprintf(":%d", config.GetInt("PORT", 5000))
-	check(e.Start(port))
+	writePidFile()
+	server := &http.Server{
+		Addr:      fmt.Sprintf(":%d", config.GetInt("PORT", 5000)),
+		TLSConfig: tlsConfig,
+	}
+	return e.StartServer(server)
+}
+
+type services struct {
+	userRepository repository.UserRepository
+	auth           service.Auth
+	oauth          service.OAuth
+	rbac           service.RBAC
+	dpop           service.DPoP
+	passwordReset  service.PasswordReset
 }
 
-func initRoutes(e *echo.Echo, db *pgx.Conn, redisClient *redis.Client) {
-	jwtMaker := jwt.NewJwtMakerFromConfig()
+func newServices(db *pgx.Conn, redisClient *redis.Client, jwtMaker jwt.Maker) (*services, error) {
 	userRepository := repository.NewUserRepository(db)
+	clientRepository := repository.NewClientRepository(db)
+	roleRepository := repository.NewRoleRepository(db)
 	tokenRepository := repository.NewTokenRepository(redisClient)
-	authService := service.NewAuthService(userRepository, tokenRepository, jwtMaker)
-	controller := handler.NewAuthHandler(authService)
+	sessionRepository := repository.NewSessionRepository(redisClient)
+	proofRepository := repository.NewProofRepository(redisClient)
+	refreshTokens, err := service.NewRefreshTokensFromConfig(jwtMaker)
+	if err != nil {
+		return nil, err
+	}
+	claimsEnrichers, err := service.NewClaimsEnrichersFromConfig()
+	if err != nil {
+		return nil, err
+	}
+	maxTokenSize := config.GetInt("ACCESS_TOKEN_MAX_SIZE", 4096)
+	authService := service.NewAuthService(userRepository, clientRepository, roleRepository, tokenRepository, sessionRepository, jwtMaker, refreshTokens,
+		service.NewSessionPolicyFromConfig(), maxTokenSize, claimsEnrichers...)
+	dpopService, err := service.NewDPoPServiceFromConfig(proofRepository)
+	if err != nil {
+		return nil, err
+	}
+	mailSender, err := mail.NewSenderFromConfig()
+	if err != nil {
+		return nil, err
+	}
+	passwordReset, err := service.NewPasswordResetServiceFromConfig(userRepository, repository.NewPasswordResetRepository(redisClient), authService, mailSender)
+	if err != nil {
+		return nil, err
+	}
+
+	return &services{
+		userRepository: userRepository,
+		auth:           authService,
+		oauth:          service.NewOAuthService(clientRepository, authService, jwtMaker, maxTokenSize),
+		rbac:           service.NewRBACService(userRepository, roleRepository),
+		dpop:           dpopService,
+		passwordReset:  passwordReset,
+	}, nil
+}
+
+func initRoutes(e *echo.Echo, services *services, jwtMaker jwt.Maker, mutualTLS bool) {
+	controller := handler.NewAuthHandler(services.auth, services.oauth, services.dpop, services.passwordReset)
+	oauthController := handler.NewOAuthHandler(services.oauth, services.dpop)
+	adminController := handler.NewAdminHandler(services.rbac)
+	authMiddleware := handler.NewAuthMiddleware(services.auth, services.dpop, jwtMaker.Audience())
+	keysController := handler.NewKeysHandler(jwtMaker, config.GetString("PUBLIC_URL", ""), config.GetDuration("JWKS_CACHE_DURATION", time.Minute*15), mutualTLS)
 
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
@@ -66,10 +153,122 @@ func initRoutes(e *echo.Echo, db *pgx.Conn, redisClient *redis.Client) {
 	authGroup.POST("/register", controller.Register)
 	authGroup.POST("/login", controller.Login)
 	authGroup.POST("/refresh", controller.Refresh)
+	authGroup.POST("/password/forgot", controller.ForgotPassword)
+	authGroup.POST("/password/reset", controller.ResetPassword)
+	authGroup.GET("/me", controller.Me, authMiddleware)
+	authGroup.GET("/sessions", controller.Sessions, authMiddleware)
+	authGroup.DELETE("/sessions/:id", controller.DeleteSession, authMiddleware)
+	authGroup.POST("/logout", controller.Logout, authMiddleware)
+	authGroup.POST("/logout-all", controller.LogoutAll, authMiddleware)
+
+	adminGroup := e.Group("/admin", authMiddleware)
+	adminGroup.GET("/roles", adminController.Roles, handler.RequirePermission("roles:read"))
+	adminGroup.POST("/roles", adminController.CreateRole, handler.RequirePermission("roles:write"))
+	adminGroup.GET("/users/:id/roles", adminController.UserRoles, handler.RequirePermission("roles:read"))
+	adminGroup.PUT("/users/:id/roles/:role", adminController.AssignRole, handler.RequirePermission("roles:write"))
+	adminGroup.DELETE("/users/:id/roles/:role", adminController.UnassignRole, handler.RequirePermission("roles:write"))
+
+	oauthGroup := e.Group("/oauth")
+	oauthGroup.POST("/introspect", oauthController.Introspect)
+	oauthGroup.POST("/revoke", oauthController.Revoke)
+	oauthGroup.POST("/token", oauthController.Token)
+
+	wellKnownGroup := e.Group("/.well-known")
+	wellKnownGroup.GET("/jwks.json", keysController.Jwks)
+	wellKnownGroup.GET("/oauth-authorization-server", keysController.Discovery)
 
 	e.GET("/swagger/*", echoSwagger.WrapHandler)
 }
 
+func rotateKeysOnSignal(jwtMaker jwt.Maker) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		if err := config.Reload(cfgPath); err != nil {
+			log.Println(err)
+			continue
+		}
+		if err := jwtMaker.RotateKeys(); err != nil {
+			log.Println(err)
+			continue
+		}
+		log.Println("signing keys rotated")
+	}
+}
+
+// getTLSConfig makes the TLS configuration of the server from TLS_CERT_FILE
+// and TLS_KEY_FILE, or returns nil to serve plain HTTP. Given
+// TLS_CLIENT_CA_FILE, client certificates are verified against it and
+// tokens issued over such connections are bound to the certificate;
+// TLS_CLIENT_AUTH=require rejects connections without one.
+func getTLSConfig() (*tls.Config, error) {
+	certFile := config.GetString("TLS_CERT_FILE", "")
+	keyFile := config.GetString("TLS_KEY_FILE", "")
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load TLS certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	caFile := config.GetString("TLS_CLIENT_CA_FILE", "")
+	if caFile == "" {
+		return tlsConfig, nil
+	}
+	caCertificates, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load TLS client CA: %w", err)
+	}
+	tlsConfig.ClientCAs = x509.NewCertPool()
+	if !tlsConfig.ClientCAs.AppendCertsFromPEM(caCertificates) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+
+	switch clientAuth := config.GetString("TLS_CLIENT_AUTH", "request"); clientAuth {
+	case "request":
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("unsupported TLS client auth %q", clientAuth)
+	}
+	return tlsConfig, nil
+}
+
+func connectPostgres() (*pgx.Conn, error) {
+	return pgx.Connect(context.Background(), getPostgresConnectionString())
+}
+
+func connectRedis() (*redis.Client, error) {
+	redisClient := redis.NewClient(&redis.Options{
+		Addr:     getRedisAddress(),
+		Password: config.GetString("REDIS_PASSWORD", ""),
+	})
+	_, err := redisClient.Ping(context.TODO()).Result()
+	if err != nil {
+		return nil, err
+	}
+	return redisClient, nil
+}
+
+// writePidFile writes the process id to PID_FILE, so that `keys rotate`
+// can signal the server.
+func writePidFile() {
+	path := config.GetString("PID_FILE", filepath.Join(os.TempDir(), "jwt-auth-demo.pid"))
+	if path == "" {
+		return
+	}
+	if err := os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+		log.Println(err)
+	}
+}
+
 func getPostgresConnectionString() string {
 	conn := fmt.Sprintf("postgres://%s:%s@%s:%d/%s",
 		config.GetString("POSTGRES_USER", "postgres"),
@@ -0,0 +1,218 @@
+// Package client talks to the auth API of jwt-auth-demo. Its transport
+// authenticates requests with the access token of the logged in session and
+// refreshes the tokens on its own, so that other services can be called
+// with a plain http.Client.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrNotLoggedIn = errors.New("not logged in")
+
+// APIError is an error response of the auth API.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return http.StatusText(e.StatusCode)
+	}
+	return e.Message
+}
+
+type RegisterRequest struct {
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Email     string `json:"email"`
+	Password  string `json:"password"`
+}
+
+type LoginRequest struct {
+	Email        string `json:"email"`
+	Password     string `json:"password"`
+	ClientId     string `json:"clientId,omitempty"`
+	ClientSecret string `json:"clientSecret,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// Client calls the auth API and keeps the tokens of the session.
+type Client interface {
+	Register(ctx context.Context, request RegisterRequest) error
+	Login(ctx context.Context, request LoginRequest) (*Tokens, error)
+	Refresh(ctx context.Context) (*Tokens, error)
+	Logout(ctx context.Context) error
+	Tokens(ctx context.Context) (*Tokens, error)
+	// Transport authenticates requests with the access token.
+	Transport() http.RoundTripper
+	// HTTPClient is an http.Client using Transport.
+	HTTPClient() *http.Client
+}
+
+// Options configures a Client. Only BaseURL is required.
+type Options struct {
+	// BaseURL is the URL the server is running at. Transport sends the
+	// access token to this scheme and host only.
+	BaseURL string
+	// Store defaults to a memory store.
+	Store TokenStore
+	// Base sends the requests, http.DefaultTransport by default. It has to
+	// present the client certificate when tokens are bound to one.
+	Base http.RoundTripper
+	// RefreshBefore is how long before it expires the access token is
+	// refreshed, 30 seconds by default.
+	RefreshBefore time.Duration
+}
+
+type client struct {
+	baseUrl       string
+	server        *url.URL
+	store         TokenStore
+	base          http.RoundTripper
+	refreshBefore time.Duration
+	refreshing    sync.Mutex
+}
+
+func New(options Options) (Client, error) {
+	if options.BaseURL == "" {
+		return nil, errors.New("base url is required")
+	}
+	server, err := url.Parse(options.BaseURL)
+	if err != nil || server.Scheme == "" || server.Host == "" {
+		return nil, errors.New("base url must be an absolute URL")
+	}
+	if options.Store == nil {
+		options.Store = NewMemoryStore()
+	}
+	if options.Base == nil {
+		options.Base = http.DefaultTransport
+	}
+	if options.RefreshBefore <= 0 {
+		options.RefreshBefore = time.Second * 30
+	}
+
+	return &client{
+		baseUrl:       strings.TrimSuffix(options.BaseURL, "/"),
+		server:        server,
+		store:         options.Store,
+		base:          options.Base,
+		refreshBefore: options.RefreshBefore,
+	}, nil
+}
+
+func (c *client) Register(ctx context.Context, request RegisterRequest) error {
+	return c.call(ctx, http.MethodPost, "/auth/register", false, request, nil)
+}
+
+func (c *client) Login(ctx context.Context, request LoginRequest) (*Tokens, error) {
+	var response Tokens
+	if err := c.call(ctx, http.MethodPost, "/auth/login", false, request, &response); err != nil {
+		return nil, err
+	}
+
+	tokens := newTokens(response.AccessToken, response.RefreshToken, response.TokenType)
+	if err := c.store.Save(ctx, tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// Refresh refreshes the tokens at once, whether they expire soon or not.
+func (c *client) Refresh(ctx context.Context) (*Tokens, error) {
+	tokens, err := c.Tokens(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.refresh(ctx, tokens)
+}
+
+// Logout revokes the session and forgets its tokens. An expired access
+// token is refreshed first. The tokens are kept if the session could not be
+// revoked, unless the server no longer knows the session.
+func (c *client) Logout(ctx context.Context) error {
+	err := c.call(ctx, http.MethodPost, "/auth/logout", true, nil, nil)
+	var apiError *APIError
+	if errors.As(err, &apiError) && apiError.StatusCode == http.StatusUnauthorized {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	return c.store.Clear(ctx)
+}
+
+func (c *client) Tokens(ctx context.Context) (*Tokens, error) {
+	tokens, err := c.store.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if tokens == nil {
+		return nil, ErrNotLoggedIn
+	}
+	return tokens, nil
+}
+
+func (c *client) Transport() http.RoundTripper {
+	return c
+}
+
+func (c *client) HTTPClient() *http.Client {
+	return &http.Client{Transport: c}
+}
+
+// call sends a JSON request to the auth API and decodes the JSON response
+// into out, unless it is nil. Authorized requests go through the
+// transport, which adds the access token and refreshes it as needed.
+func (c *client) call(ctx context.Context, method, path string, authorized bool, in, out interface{}) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, c.baseUrl+path, &body)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "application/json")
+	transport := c.base
+	if authorized {
+		transport = c
+	}
+	response, err := transport.RoundTrip(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 400 {
+		apiError := &APIError{StatusCode: response.StatusCode}
+		var message struct {
+			Message string `json:"message"`
+		}
+		if json.NewDecoder(response.Body).Decode(&message) == nil {
+			apiError.Message = message.Message
+		}
+		return apiError
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(response.Body).Decode(out); err != nil {
+		return fmt.Errorf("cannot decode response: %w", err)
+	}
+	return nil
+}
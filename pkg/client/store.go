@@ -0,0 +1,114 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// TokenStore persists the tokens of the client. Load returns nil when no
+// tokens are stored.
+type TokenStore interface {
+	Load(ctx context.Context) (*Tokens, error)
+	Save(ctx context.Context, tokens *Tokens) error
+	Clear(ctx context.Context) error
+}
+
+type memoryStore struct {
+	mu     sync.RWMutex
+	tokens *Tokens
+}
+
+// NewMemoryStore keeps the tokens for the life of the process.
+func NewMemoryStore() *memoryStore {
+	return &memoryStore{}
+}
+
+func (s *memoryStore) Load(_ context.Context) (*Tokens, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.tokens == nil {
+		return nil, nil
+	}
+	tokens := *s.tokens
+	return &tokens, nil
+}
+
+func (s *memoryStore) Save(_ context.Context, tokens *Tokens) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	saved := *tokens
+	s.tokens = &saved
+	return nil
+}
+
+func (s *memoryStore) Clear(_ context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = nil
+	return nil
+}
+
+type fileStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileStore keeps the tokens in a JSON file readable only by the user.
+func NewFileStore(path string) *fileStore {
+	return &fileStore{path: path}
+}
+
+func (s *fileStore) Load(_ context.Context) (*Tokens, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := new(Tokens)
+	if err := json.Unmarshal(data, tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (s *fileStore) Save(_ context.Context, tokens *Tokens) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+
+	// Written to a temporary file first, so that a crash never leaves
+	// the tokens half written.
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s *fileStore) Clear(_ context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := os.Remove(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
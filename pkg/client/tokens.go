@@ -0,0 +1,56 @@
+package client
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Tokens are the tokens of a session, as returned by login and refresh.
+type Tokens struct {
+	AccessToken  string    `json:"accessToken"`
+	RefreshToken string    `json:"refreshToken"`
+	TokenType    string    `json:"tokenType"`
+	ExpiresAt    time.Time `json:"expiresAt,omitempty"`
+}
+
+func newTokens(accessToken, refreshToken, tokenType string) *Tokens {
+	if tokenType == "" {
+		tokenType = "Bearer"
+	}
+	return &Tokens{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    tokenType,
+		ExpiresAt:    accessTokenExpiry(accessToken),
+	}
+}
+
+// expiresWithin tells whether the access token expires in less than the
+// duration. Tokens of an unknown expiry are only refreshed once rejected.
+func (t *Tokens) expiresWithin(d time.Duration) bool {
+	return !t.ExpiresAt.IsZero() && time.Until(t.ExpiresAt) < d
+}
+
+// accessTokenExpiry reads the "exp" claim of a JWT access token without
+// verifying it; the client only uses it to schedule refreshes. PASETO
+// tokens are opaque to the client, so their expiry is unknown.
+func accessTokenExpiry(accessToken string) time.Time {
+	parts := strings.Split(accessToken, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}
+	}
+
+	var claims struct {
+		ExpiresAt int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.ExpiresAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.ExpiresAt, 0)
+}
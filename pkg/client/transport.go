@@ -0,0 +1,92 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// RoundTrip sends the request with the access token, refreshing the tokens
+// first when the access token is about to expire. A request rejected with
+// 401 is sent once more with refreshed tokens, provided its body can be
+// sent again. Requests to other hosts than the server, such as the ones a
+// redirect leads to, are sent as they are.
+func (c *client) RoundTrip(request *http.Request) (*http.Response, error) {
+	if !c.isServer(request) {
+		return c.base.RoundTrip(request)
+	}
+
+	ctx := request.Context()
+	tokens, err := c.Tokens(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if tokens.expiresWithin(c.refreshBefore) {
+		if tokens, err = c.refresh(ctx, tokens); err != nil {
+			return nil, err
+		}
+	}
+
+	response, err := c.send(request, tokens)
+	if err != nil || response.StatusCode != http.StatusUnauthorized {
+		return response, err
+	}
+	if request.Body != nil && request.GetBody == nil {
+		return response, nil
+	}
+
+	refreshed, err := c.refresh(ctx, tokens)
+	if err != nil {
+		return response, nil
+	}
+	response.Body.Close()
+	return c.send(request, refreshed)
+}
+
+func (c *client) send(request *http.Request, tokens *Tokens) (*http.Response, error) {
+	authorized := request.Clone(request.Context())
+	if request.Body != nil && request.GetBody != nil {
+		body, err := request.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		authorized.Body = body
+	}
+	authorized.Header.Set("Authorization", tokens.TokenType+" "+tokens.AccessToken)
+	return c.base.RoundTrip(authorized)
+}
+
+// refresh trades the refresh token for new tokens. Concurrent refreshes of
+// the same tokens are done once: the callers waiting for the first one pick
+// up the tokens it stored instead of spending the refresh token again,
+// which the server would take for a replay.
+func (c *client) refresh(ctx context.Context, stale *Tokens) (*Tokens, error) {
+	c.refreshing.Lock()
+	defer c.refreshing.Unlock()
+
+	current, err := c.Tokens(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if current.AccessToken != stale.AccessToken {
+		return current, nil
+	}
+
+	var response Tokens
+	err = c.call(ctx, http.MethodPost, "/auth/refresh", false, map[string]string{"refreshToken": current.RefreshToken}, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := newTokens(response.AccessToken, response.RefreshToken, response.TokenType)
+	if err := c.store.Save(ctx, tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// isServer tells whether the request is sent to the scheme and the host of
+// the base URL, the only ones trusted with the access token.
+func (c *client) isServer(request *http.Request) bool {
+	return strings.EqualFold(request.URL.Scheme, c.server.Scheme) && strings.EqualFold(request.URL.Host, c.server.Host)
+}
@@ -0,0 +1,251 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// authServer issues tokens on /auth/refresh and accepts only the latest
+// access token on /api. A refresh token is good for a single refresh.
+type authServer struct {
+	*httptest.Server
+	mu           sync.Mutex
+	generation   int
+	accessToken  string
+	refreshToken string
+	expiresIn    time.Duration
+	refreshDelay time.Duration
+	refreshes    int
+	replays      int
+	apiRequests  int
+	rejectAll    bool
+	receivedBody []string
+}
+
+func newAuthServer(t *testing.T, expiresIn time.Duration) *authServer {
+	s := &authServer{expiresIn: expiresIn}
+	s.issue()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/auth/refresh", s.refresh)
+	mux.HandleFunc("/api", s.api)
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+// issue makes the next generation of tokens, the caller holding the lock.
+func (s *authServer) issue() *Tokens {
+	s.generation++
+	s.accessToken = testAccessToken(s.generation, time.Now().Add(s.expiresIn))
+	s.refreshToken = fmt.Sprintf("refresh-%d", s.generation)
+	return &Tokens{AccessToken: s.accessToken, RefreshToken: s.refreshToken, TokenType: "Bearer"}
+}
+
+func (s *authServer) refresh(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	json.NewDecoder(r.Body).Decode(&request)
+	time.Sleep(s.refreshDelay)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refreshes++
+	if request.RefreshToken != s.refreshToken {
+		s.replays++
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	json.NewEncoder(w).Encode(s.issue())
+}
+
+func (s *authServer) api(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.apiRequests++
+	s.receivedBody = append(s.receivedBody, string(body))
+	if s.rejectAll || r.Header.Get("Authorization") != "Bearer "+s.accessToken {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// revoke makes the current access token rejected while its refresh token
+// still works.
+func (s *authServer) revoke() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accessToken = "revoked"
+}
+
+func (s *authServer) stats() (refreshes, replays, apiRequests int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.refreshes, s.replays, s.apiRequests
+}
+
+// testAccessToken is shaped like a JWT so that the client reads its expiry.
+func testAccessToken(generation int, expiresAt time.Time) string {
+	payload := fmt.Sprintf(`{"exp":%d,"jti":"%d"}`, expiresAt.Unix(), generation)
+	return "eyJhbGciOiJub25lIn0." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".signature"
+}
+
+// newLoggedInClient makes a client holding the current tokens of the server.
+func newLoggedInClient(t *testing.T, server *authServer) *client {
+	server.mu.Lock()
+	tokens := newTokens(server.accessToken, server.refreshToken, "Bearer")
+	server.mu.Unlock()
+
+	c, err := New(Options{BaseURL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.(*client).store.Save(context.Background(), tokens); err != nil {
+		t.Fatal(err)
+	}
+	return c.(*client)
+}
+
+func TestTransport(t *testing.T) {
+	tests := []struct {
+		name        string
+		expiresIn   time.Duration
+		revoke      bool
+		rejectAll   bool
+		body        func() io.Reader
+		status      int
+		refreshes   int
+		apiRequests int
+	}{
+		{name: "valid token", expiresIn: time.Minute, status: http.StatusOK, apiRequests: 1},
+		{name: "token about to expire is refreshed first", expiresIn: time.Second * 10, status: http.StatusOK, refreshes: 1, apiRequests: 1},
+		{name: "rejected token is refreshed and sent again", expiresIn: time.Minute, revoke: true, status: http.StatusOK, refreshes: 1, apiRequests: 2},
+		{
+			name:        "rejected request is sent again with its body",
+			expiresIn:   time.Minute,
+			revoke:      true,
+			body:        func() io.Reader { return strings.NewReader("payload") },
+			status:      http.StatusOK,
+			refreshes:   1,
+			apiRequests: 2,
+		},
+		{
+			name:        "rejected request with a body that cannot be sent again",
+			expiresIn:   time.Minute,
+			revoke:      true,
+			body:        func() io.Reader { return ioutil.NopCloser(strings.NewReader("payload")) },
+			status:      http.StatusUnauthorized,
+			apiRequests: 1,
+		},
+		{name: "rejected again after a refresh", expiresIn: time.Minute, rejectAll: true, status: http.StatusUnauthorized, refreshes: 1, apiRequests: 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newAuthServer(t, test.expiresIn)
+			c := newLoggedInClient(t, server)
+			if test.revoke {
+				server.revoke()
+			}
+			server.rejectAll = test.rejectAll
+
+			method, body := http.MethodGet, io.Reader(nil)
+			if test.body != nil {
+				method, body = http.MethodPost, test.body()
+			}
+			request, err := http.NewRequest(method, server.URL+"/api", body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			response, err := c.HTTPClient().Do(request)
+			if err != nil {
+				t.Fatalf("got error %v, want none", err)
+			}
+			response.Body.Close()
+
+			if response.StatusCode != test.status {
+				t.Fatalf("got status %d, want %d", response.StatusCode, test.status)
+			}
+			refreshes, _, apiRequests := server.stats()
+			if refreshes != test.refreshes || apiRequests != test.apiRequests {
+				t.Fatalf("got %d refreshes and %d requests, want %d and %d", refreshes, apiRequests, test.refreshes, test.apiRequests)
+			}
+			if test.body != nil && test.status == http.StatusOK {
+				for _, received := range server.receivedBody {
+					if received != "payload" {
+						t.Fatalf("got body %q, want %q", received, "payload")
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestTransportRefreshesOnce(t *testing.T) {
+	server := newAuthServer(t, time.Second*10)
+	server.refreshDelay = time.Millisecond * 50
+	c := newLoggedInClient(t, server)
+
+	const concurrency = 8
+	var wg sync.WaitGroup
+	statuses := make(chan int, concurrency)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response, err := c.HTTPClient().Get(server.URL + "/api")
+			if err != nil {
+				statuses <- 0
+				return
+			}
+			response.Body.Close()
+			statuses <- response.StatusCode
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	for status := range statuses {
+		if status != http.StatusOK {
+			t.Fatalf("got status %d, want %d", status, http.StatusOK)
+		}
+	}
+	if refreshes, replays, _ := server.stats(); refreshes != 1 || replays != 0 {
+		t.Fatalf("got %d refreshes and %d replays, want a single refresh", refreshes, replays)
+	}
+}
+
+func TestTransportKeepsTokenToServer(t *testing.T) {
+	server := newAuthServer(t, time.Minute)
+	c := newLoggedInClient(t, server)
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer other.Close()
+
+	response, err := c.HTTPClient().Get(other.URL + "/api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatal("access token has been sent to another host")
+	}
+}
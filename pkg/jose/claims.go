@@ -0,0 +1,234 @@
+// Package jose holds the claims, the JSON Web Keys and the EdDSA signing
+// method of the tokens issued by jwt-auth-demo, shared by the server and
+// by the packages verifying its tokens.
+package jose
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Claims is the claim set of the tokens issued by the server. Claims that
+// have no dedicated field are kept in Extra and are serialized next to the
+// registered ones. The subject is the id of the user, serialized as a
+// string as RFC 7519 requires.
+type Claims struct {
+	Subject      int                    `json:"sub,string"`
+	Email        string                 `json:"email,omitempty"`
+	Id           string                 `json:"jti"`
+	IssuedAt     int64                  `json:"iat"`
+	ExpiresAt    int64                  `json:"exp"`
+	NotBefore    int64                  `json:"nbf,omitempty"`
+	Audience     Audience               `json:"aud,omitempty"`
+	Issuer       string                 `json:"iss,omitempty"`
+	ClientId     string                 `json:"client_id,omitempty"`
+	SessionId    string                 `json:"sid,omitempty"`
+	Scope        string                 `json:"scope,omitempty"`
+	Roles        []string               `json:"roles,omitempty"`
+	Permissions  []string               `json:"permissions,omitempty"`
+	Confirmation *Confirmation          `json:"cnf,omitempty"`
+	Actor        *Actor                 `json:"act,omitempty"`
+	Extra        map[string]interface{} `json:"-"`
+}
+
+// Confirmation binds a token to a key held by its owner (RFC 7800), who
+// has to prove possession of the key to use the token.
+type Confirmation struct {
+	JwkThumbprint         string `json:"jkt,omitempty"`
+	CertificateThumbprint string `json:"x5t#S256,omitempty"`
+}
+
+// Actor identifies the party a token has been delegated to, which acts on
+// behalf of the subject (RFC 8693). A chain of delegations nests the
+// previous actors.
+type Actor struct {
+	Subject  string `json:"sub"`
+	ClientId string `json:"client_id,omitempty"`
+	Actor    *Actor `json:"act,omitempty"`
+}
+
+// CertificateThumbprint computes the "x5t#S256" confirmation of a client
+// certificate.
+func CertificateThumbprint(certificate *x509.Certificate) string {
+	sum := sha256.Sum256(certificate.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+type registeredClaims Claims
+
+var registeredClaimNames = getRegisteredClaimNames()
+
+// IsRegisteredClaim tells whether the claim has a dedicated field in Claims
+// and therefore cannot be set through Extra.
+func IsRegisteredClaim(name string) bool {
+	return registeredClaimNames[name]
+}
+
+func (c *Claims) Valid() error {
+	return c.Validate(time.Now(), 0)
+}
+
+// Validate checks the times of the token, allowing for leeway of clock
+// skew.
+func (c *Claims) Validate(now time.Time, leeway time.Duration) error {
+	if c.ExpiresAt != 0 && !now.Add(-leeway).Before(c.ExpiresAtTime()) {
+		return errors.New("token is expired")
+	}
+	if c.NotBefore != 0 && now.Add(leeway).Before(time.Unix(c.NotBefore, 0)) {
+		return errors.New("token is not valid yet")
+	}
+	if c.IssuedAt != 0 && now.Add(leeway).Before(c.IssuedAtTime()) {
+		return errors.New("token used before issued")
+	}
+	return nil
+}
+
+// Scopes returns the space-delimited "scope" claim as a list.
+func (c *Claims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+func (c *Claims) HasScope(scope string) bool {
+	return contains(c.Scopes(), scope)
+}
+
+func (c *Claims) HasRole(role string) bool {
+	return contains(c.Roles, role)
+}
+
+func (c *Claims) HasPermission(permission string) bool {
+	return contains(c.Permissions, permission)
+}
+
+// IsDPoPBound tells whether the token can only be used with DPoP proofs.
+func (c *Claims) IsDPoPBound() bool {
+	return c.Confirmation != nil && c.Confirmation.JwkThumbprint != ""
+}
+
+// IsCertificateBound tells whether the token can only be used over mutual
+// TLS connections authenticated by a certificate (RFC 8705).
+func (c *Claims) IsCertificateBound() bool {
+	return c.Confirmation != nil && c.Confirmation.CertificateThumbprint != ""
+}
+
+func (c *Claims) IssuedAtTime() time.Time {
+	return time.Unix(c.IssuedAt, 0)
+}
+
+func (c *Claims) ExpiresAtTime() time.Time {
+	return time.Unix(c.ExpiresAt, 0)
+}
+
+func (c Claims) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(registeredClaims(c))
+	if err != nil || len(c.Extra) == 0 {
+		return data, err
+	}
+
+	merged := make(map[string]interface{}, len(c.Extra))
+	for name, value := range c.Extra {
+		if !registeredClaimNames[name] {
+			merged[name] = value
+		}
+	}
+	if err := json.Unmarshal(data, &merged); err != nil {
+		return nil, err
+	}
+	return json.Marshal(merged)
+}
+
+func (c *Claims) UnmarshalJSON(data []byte) error {
+	data, err := quoteNumericSubject(data)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, (*registeredClaims)(c)); err != nil {
+		return err
+	}
+
+	var all map[string]interface{}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return err
+	}
+	for name := range registeredClaimNames {
+		delete(all, name)
+	}
+	if len(all) > 0 {
+		c.Extra = all
+	} else {
+		c.Extra = nil
+	}
+	return nil
+}
+
+// quoteNumericSubject turns a numeric "sub" into a string, so that tokens
+// issued before the subject was serialized as a string are still read.
+func quoteNumericSubject(data []byte) ([]byte, error) {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil {
+		return nil, err
+	}
+	sub, ok := members["sub"]
+	if !ok || len(sub) == 0 || sub[0] == '"' || string(sub) == "null" {
+		return data, nil
+	}
+	members["sub"] = json.RawMessage(strconv.Quote(string(sub)))
+	return json.Marshal(members)
+}
+
+// Audience accepts both the single string and the array form of "aud".
+type Audience []string
+
+func (a Audience) Contains(audience string) bool {
+	return contains(a, audience)
+}
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return errors.New("aud must be a string or an array of strings")
+	}
+	*a = multiple
+	return nil
+}
+
+func getRegisteredClaimNames() map[string]bool {
+	names := make(map[string]bool)
+	t := reflect.TypeOf(registeredClaims{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package jose
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestClaimsSubjectIsAString(t *testing.T) {
+	data, err := json.Marshal(&Claims{Subject: 42, Id: "token-id", Extra: map[string]interface{}{"tenant": "acme"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var members map[string]interface{}
+	if err := json.Unmarshal(data, &members); err != nil {
+		t.Fatal(err)
+	}
+	if members["sub"] != "42" {
+		t.Fatalf("got sub %#v, want \"42\"", members["sub"])
+	}
+	if members["tenant"] != "acme" {
+		t.Fatalf("got tenant %#v, want \"acme\"", members["tenant"])
+	}
+}
+
+func TestUnmarshalClaimsSubject(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		subject int
+		extra   map[string]interface{}
+		err     bool
+	}{
+		{name: "string", data: `{"sub":"42","jti":"token-id"}`, subject: 42},
+		{name: "number of tokens issued before", data: `{"sub":42,"jti":"token-id"}`, subject: 42},
+		{name: "with extra claims", data: `{"sub":"42","tenant":"acme"}`, subject: 42, extra: map[string]interface{}{"tenant": "acme"}},
+		{name: "missing", data: `{"jti":"token-id"}`},
+		{name: "not a user id", data: `{"sub":"jane"}`, err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims := new(Claims)
+			err := json.Unmarshal([]byte(test.data), claims)
+			if test.err {
+				if err == nil {
+					t.Fatal("got no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error %v, want none", err)
+			}
+			if claims.Subject != test.subject {
+				t.Fatalf("got subject %d, want %d", claims.Subject, test.subject)
+			}
+			if !reflect.DeepEqual(claims.Extra, test.extra) {
+				t.Fatalf("got extra claims %v, want %v", claims.Extra, test.extra)
+			}
+		})
+	}
+}
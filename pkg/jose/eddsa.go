@@ -0,0 +1,51 @@
+package jose
+
+import (
+	"crypto/ed25519"
+	"errors"
+
+	jwtgo "github.com/dgrijalva/jwt-go"
+)
+
+var errEd25519Verification = errors.New("crypto/ed25519: verification error")
+
+// SigningMethodEdDSA signs with Ed25519 keys, which jwt-go does not
+// support. It is registered for the "EdDSA" algorithm.
+var SigningMethodEdDSA = &SigningMethodEd25519{}
+
+func init() {
+	jwtgo.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwtgo.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+type SigningMethodEd25519 struct{}
+
+func (m *SigningMethodEd25519) Alg() string {
+	return "EdDSA"
+}
+
+func (m *SigningMethodEd25519) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok || len(privateKey) != ed25519.PrivateKeySize {
+		return "", jwtgo.ErrInvalidKeyType
+	}
+	return jwtgo.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
+
+func (m *SigningMethodEd25519) Verify(signingString, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok || len(publicKey) != ed25519.PublicKeySize {
+		return jwtgo.ErrInvalidKeyType
+	}
+
+	sig, err := jwtgo.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return errEd25519Verification
+	}
+	return nil
+}
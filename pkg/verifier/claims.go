@@ -0,0 +1,6 @@
+package verifier
+
+import "github.com/evleria/jwt-auth-demo/pkg/jose"
+
+// Claims is the claim set of the access tokens issued by jwt-auth-demo.
+type Claims = jose.Claims
@@ -0,0 +1,112 @@
+package verifier
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/evleria/jwt-auth-demo/pkg/jose"
+)
+
+// keySet caches the JSON Web Key Set of the server. Keys are refetched once
+// they are older than refreshInterval, or when a token names an unknown
+// key, but not more often than every minRefreshInterval. Known keys keep
+// being served while the server cannot be reached.
+type keySet struct {
+	url                string
+	client             *http.Client
+	refreshInterval    time.Duration
+	minRefreshInterval time.Duration
+
+	mu        sync.RWMutex
+	keys      map[string]publicKey
+	fetchedAt time.Time
+	fetching  sync.Mutex
+}
+
+type publicKey struct {
+	alg string
+	key crypto.PublicKey
+}
+
+func (s *keySet) lookup(ctx context.Context, kid string) (publicKey, error) {
+	s.mu.RLock()
+	key, ok := s.keys[kid]
+	stale := time.Since(s.fetchedAt) > s.refreshInterval
+	s.mu.RUnlock()
+
+	if ok && !stale {
+		return key, nil
+	}
+	if err := s.refresh(ctx); err != nil && !ok {
+		return publicKey{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if key, ok := s.keys[kid]; ok {
+		return key, nil
+	}
+	return publicKey{}, errors.New("unknown signing key")
+}
+
+func (s *keySet) refresh(ctx context.Context) error {
+	s.fetching.Lock()
+	defer s.fetching.Unlock()
+
+	s.mu.RLock()
+	recent := time.Since(s.fetchedAt) < s.minRefreshInterval
+	s.mu.RUnlock()
+	if recent {
+		return nil
+	}
+
+	keys, err := s.fetch(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.keys = keys
+	s.fetchedAt = time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *keySet) fetch(ctx context.Context) (map[string]publicKey, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := s.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch keys: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cannot fetch keys: %s", response.Status)
+	}
+
+	var set jose.JSONWebKeySet
+	if err := json.NewDecoder(response.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("cannot decode keys: %w", err)
+	}
+
+	keys := make(map[string]publicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Kid == "" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = publicKey{alg: jwk.Alg, key: key}
+	}
+	return keys, nil
+}
@@ -0,0 +1,94 @@
+package verifier
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+type contextKey struct{}
+
+const echoContextKey = "verifier.claims"
+
+// Middleware authenticates requests to a net/http handler by the Bearer
+// access token of their Authorization header, and puts the claims into
+// the request context.
+func Middleware(v Verifier) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, err := authenticate(v, r)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, claims)))
+		})
+	}
+}
+
+// ClaimsFromContext returns the claims put by Middleware, or nil.
+func ClaimsFromContext(ctx context.Context) *Claims {
+	claims, _ := ctx.Value(contextKey{}).(*Claims)
+	return claims
+}
+
+// EchoMiddleware is Middleware for Echo; the claims are returned by
+// EchoClaims.
+func EchoMiddleware(v Verifier) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			claims, err := authenticate(v, ctx.Request())
+			if err != nil {
+				ctx.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+				return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+			}
+			ctx.Set(echoContextKey, claims)
+			return next(ctx)
+		}
+	}
+}
+
+func EchoClaims(ctx echo.Context) *Claims {
+	claims, _ := ctx.Get(echoContextKey).(*Claims)
+	return claims
+}
+
+// authenticate verifies the access token of the request. Tokens bound to a
+// TLS client certificate are only accepted over connections authenticated by
+// that certificate. DPoP-bound tokens are refused, as verifying their proofs
+// takes a replay cache shared with the server.
+func authenticate(v Verifier, r *http.Request) (*Claims, error) {
+	parts := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") || parts[1] == "" {
+		return nil, errors.New("missing access token")
+	}
+
+	claims, err := v.Verify(r.Context(), parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	if cnf := claims.Confirmation; cnf != nil {
+		if cnf.JwkThumbprint != "" {
+			return nil, errors.New("DPoP-bound access tokens are not supported")
+		}
+		if cnf.CertificateThumbprint != "" && cnf.CertificateThumbprint != certificateThumbprint(r) {
+			return nil, errors.New("access token is bound to another certificate")
+		}
+	}
+	return claims, nil
+}
+
+func certificateThumbprint(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(r.TLS.PeerCertificates[0].Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
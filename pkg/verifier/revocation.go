@@ -0,0 +1,71 @@
+package verifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// RevocationChecker tells whether an access token has been revoked before
+// it expired, e.g. because its session was logged out.
+type RevocationChecker interface {
+	IsRevoked(ctx context.Context, accessToken string, claims *Claims) (bool, error)
+}
+
+type RevocationCheckerFunc func(ctx context.Context, accessToken string, claims *Claims) (bool, error)
+
+func (f RevocationCheckerFunc) IsRevoked(ctx context.Context, accessToken string, claims *Claims) (bool, error) {
+	return f(ctx, accessToken, claims)
+}
+
+type introspection struct {
+	url          string
+	clientId     string
+	clientSecret string
+	client       *http.Client
+}
+
+// NewIntrospectionChecker asks the introspection endpoint of the server
+// (RFC 7662) about every token, authenticated as a confidential client. It
+// costs a request per token, in exchange revoked tokens are refused at once.
+func NewIntrospectionChecker(introspectionUrl, clientId, clientSecret string, client *http.Client) *introspection {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &introspection{
+		url:          introspectionUrl,
+		clientId:     clientId,
+		clientSecret: clientSecret,
+		client:       client,
+	}
+}
+
+func (i *introspection) IsRevoked(ctx context.Context, accessToken string, _ *Claims) (bool, error) {
+	form := url.Values{"token": {accessToken}, "token_type_hint": {"access_token"}}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, i.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.SetBasicAuth(url.QueryEscape(i.clientId), url.QueryEscape(i.clientSecret))
+
+	response, err := i.client.Do(request)
+	if err != nil {
+		return false, fmt.Errorf("cannot introspect token: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("cannot introspect token: %s", response.Status)
+	}
+
+	var result struct {
+		Active bool `json:"active"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return false, fmt.Errorf("cannot decode introspection: %w", err)
+	}
+	return !result.Active, nil
+}
@@ -0,0 +1,153 @@
+// Package verifier verifies the access tokens issued by jwt-auth-demo in
+// other services. It fetches the public keys of the server from its JWKS
+// endpoint, so the server has to sign access tokens with an asymmetric key
+// (RS*, PS*, ES* or EdDSA) in the JWT format.
+package verifier
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	jwtgo "github.com/dgrijalva/jwt-go"
+)
+
+const accessTokenType = "at+jwt"
+
+var (
+	ErrInvalidToken = errors.New("invalid access token")
+	ErrTokenRevoked = errors.New("access token has been revoked")
+)
+
+// Verifier verifies access tokens and returns their claims.
+type Verifier interface {
+	Verify(ctx context.Context, accessToken string) (*Claims, error)
+}
+
+// Options configures a Verifier. Issuer is required, and so is JwksURL
+// unless the issuer is the URL of the server.
+type Options struct {
+	// Issuer has to match the "iss" claim of the tokens, which is the
+	// TOKEN_ISSUER of the server.
+	Issuer string
+	// Audience, when set, has to be one of the "aud" claim of the tokens.
+	Audience string
+	// JwksURL defaults to Issuer + "/.well-known/jwks.json" when the issuer
+	// is an http or https URL.
+	JwksURL string
+	// HTTPClient defaults to a client with a 10 seconds timeout.
+	HTTPClient *http.Client
+	// RefreshInterval is how long the keys are cached, 15 minutes by default.
+	RefreshInterval time.Duration
+	// MinRefreshInterval limits how often tokens signed by unknown keys
+	// make the keys refetched, once a minute by default.
+	MinRefreshInterval time.Duration
+	// Leeway allows for clock skew when checking the times of the tokens,
+	// 30 seconds by default.
+	Leeway time.Duration
+	// Revocation, when set, is asked whether a valid token has been revoked.
+	Revocation RevocationChecker
+}
+
+type verifier struct {
+	issuer     string
+	audience   string
+	leeway     time.Duration
+	keys       *keySet
+	revocation RevocationChecker
+	parser     *jwtgo.Parser
+}
+
+func New(options Options) (Verifier, error) {
+	if options.Issuer == "" {
+		return nil, errors.New("issuer is required")
+	}
+	if options.JwksURL == "" {
+		if !isHTTPURL(options.Issuer) {
+			return nil, errors.New("jwks url is required when the issuer is not a URL")
+		}
+		options.JwksURL = strings.TrimSuffix(options.Issuer, "/") + "/.well-known/jwks.json"
+	}
+	if options.HTTPClient == nil {
+		options.HTTPClient = &http.Client{Timeout: time.Second * 10}
+	}
+	if options.RefreshInterval <= 0 {
+		options.RefreshInterval = time.Minute * 15
+	}
+	if options.MinRefreshInterval <= 0 {
+		options.MinRefreshInterval = time.Minute
+	}
+	if options.Leeway <= 0 {
+		options.Leeway = time.Second * 30
+	}
+
+	return &verifier{
+		issuer:   options.Issuer,
+		audience: options.Audience,
+		leeway:   options.Leeway,
+		keys: &keySet{
+			url:                options.JwksURL,
+			client:             options.HTTPClient,
+			refreshInterval:    options.RefreshInterval,
+			minRefreshInterval: options.MinRefreshInterval,
+		},
+		revocation: options.Revocation,
+		parser: &jwtgo.Parser{
+			SkipClaimsValidation: true,
+			ValidMethods:         []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"},
+		},
+	}, nil
+}
+
+// Verify checks the signature, the type, the issuer, the audience and the
+// times of the token, and whether it has been revoked if revocation is
+// checked.
+func (v *verifier) Verify(ctx context.Context, accessToken string) (*Claims, error) {
+	claims := new(Claims)
+	_, err := v.parser.ParseWithClaims(accessToken, claims, func(t *jwtgo.Token) (interface{}, error) {
+		if typ, _ := t.Header["typ"].(string); typ != accessTokenType {
+			return nil, errors.New("unexpected token type")
+		}
+		kid, _ := t.Header["kid"].(string)
+		key, err := v.keys.lookup(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+		if key.alg != "" && key.alg != t.Method.Alg() {
+			return nil, errors.New("unexpected signing method")
+		}
+		return key.key, nil
+	})
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if claims.ExpiresAt == 0 || claims.Validate(time.Now(), v.leeway) != nil {
+		return nil, ErrInvalidToken
+	}
+	if claims.Issuer != v.issuer {
+		return nil, ErrInvalidToken
+	}
+	if v.audience != "" && !claims.Audience.Contains(v.audience) {
+		return nil, ErrInvalidToken
+	}
+
+	if v.revocation != nil {
+		revoked, err := v.revocation.IsRevoked(ctx, accessToken, claims)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			return nil, ErrTokenRevoked
+		}
+	}
+	return claims, nil
+}
+
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
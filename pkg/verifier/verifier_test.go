@@ -0,0 +1,376 @@
+package verifier
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"github.com/evleria/jwt-auth-demo/pkg/jose"
+)
+
+const testIssuer = "https://auth.example.com"
+
+// jwksServer serves a JSON Web Key Set and counts how often it is fetched.
+type jwksServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	keys    []jose.JSONWebKey
+	status  int
+	fetches int
+}
+
+func newJwksServer(t *testing.T, keys ...jose.JSONWebKey) *jwksServer {
+	s := &jwksServer{keys: keys, status: http.StatusOK}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.fetches++
+		if s.status != http.StatusOK {
+			w.WriteHeader(s.status)
+			return
+		}
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: s.keys})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) set(status int, keys ...jose.JSONWebKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+	s.keys = keys
+}
+
+func (s *jwksServer) fetchCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetches
+}
+
+type testKey struct {
+	kid        string
+	privateKey *ecdsa.PrivateKey
+}
+
+func newTestKey(t *testing.T, kid string) *testKey {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testKey{kid: kid, privateKey: privateKey}
+}
+
+func (k *testKey) jwk(t *testing.T) jose.JSONWebKey {
+	jwk, err := jose.NewJSONWebKey(&k.privateKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk.Kid, jwk.Alg, jwk.Use = k.kid, "ES256", "sig"
+	return jwk
+}
+
+func (k *testKey) sign(t *testing.T, claims *Claims) string {
+	return signToken(t, jwtgo.SigningMethodES256, k.privateKey, k.kid, accessTokenType, claims)
+}
+
+func signToken(t *testing.T, method jwtgo.SigningMethod, key interface{}, kid, typ string, claims *Claims) string {
+	token := jwtgo.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	token.Header["typ"] = typ
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func validClaims() *Claims {
+	now := time.Now()
+	return &Claims{
+		Subject:   1,
+		Id:        "token-id",
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(time.Minute).Unix(),
+		Audience:  jose.Audience{"orders", "billing"},
+		Issuer:    testIssuer,
+	}
+}
+
+func TestVerify(t *testing.T) {
+	key := newTestKey(t, "key-1")
+	server := newJwksServer(t, key.jwk(t))
+	v, err := New(Options{Issuer: testIssuer, Audience: "billing", JwksURL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token func() string
+		ok    bool
+	}{
+		{name: "valid", token: func() string { return key.sign(t, validClaims()) }, ok: true},
+		{
+			name: "another issuer",
+			token: func() string {
+				claims := validClaims()
+				claims.Issuer = "https://evil.example.com"
+				return key.sign(t, claims)
+			},
+		},
+		{
+			name: "another audience",
+			token: func() string {
+				claims := validClaims()
+				claims.Audience = jose.Audience{"orders"}
+				return key.sign(t, claims)
+			},
+		},
+		{
+			name: "expired within the leeway",
+			token: func() string {
+				claims := validClaims()
+				claims.ExpiresAt = time.Now().Add(-time.Second * 10).Unix()
+				return key.sign(t, claims)
+			},
+			ok: true,
+		},
+		{
+			name: "expired",
+			token: func() string {
+				claims := validClaims()
+				claims.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+				return key.sign(t, claims)
+			},
+		},
+		{
+			name: "without expiry",
+			token: func() string {
+				claims := validClaims()
+				claims.ExpiresAt = 0
+				return key.sign(t, claims)
+			},
+		},
+		{
+			name: "not valid yet",
+			token: func() string {
+				claims := validClaims()
+				claims.NotBefore = time.Now().Add(time.Minute).Unix()
+				return key.sign(t, claims)
+			},
+		},
+		{
+			name: "refresh token",
+			token: func() string {
+				return signToken(t, jwtgo.SigningMethodES256, key.privateKey, key.kid, "refresh+jwt", validClaims())
+			},
+		},
+		{
+			name: "unknown key",
+			token: func() string {
+				return newTestKey(t, "key-2").sign(t, validClaims())
+			},
+		},
+		{
+			name: "known key id signed by another key",
+			token: func() string {
+				return newTestKey(t, key.kid).sign(t, validClaims())
+			},
+		},
+		{
+			name: "symmetric algorithm",
+			token: func() string {
+				return signToken(t, jwtgo.SigningMethodHS256, []byte("secret"), key.kid, accessTokenType, validClaims())
+			},
+		},
+		{name: "malformed", token: func() string { return "not.a.token" }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims, err := v.Verify(context.Background(), test.token())
+			if test.ok {
+				if err != nil {
+					t.Fatalf("got error %v, want none", err)
+				}
+				if claims.Subject != 1 {
+					t.Fatalf("got subject %d, want 1", claims.Subject)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Fatalf("got error %v, want %v", err, ErrInvalidToken)
+			}
+		})
+	}
+}
+
+func TestVerifyCachesKeys(t *testing.T) {
+	first := newTestKey(t, "key-1")
+	second := newTestKey(t, "key-2")
+	server := newJwksServer(t, first.jwk(t))
+	v, err := New(Options{Issuer: testIssuer, JwksURL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := v.Verify(context.Background(), first.sign(t, validClaims())); err != nil {
+			t.Fatalf("got error %v, want none", err)
+		}
+	}
+	if fetches := server.fetchCount(); fetches != 1 {
+		t.Fatalf("got %d fetches, want 1", fetches)
+	}
+
+	// Unknown keys do not make the keys refetched more often than every
+	// MinRefreshInterval.
+	server.set(http.StatusOK, first.jwk(t), second.jwk(t))
+	if _, err := v.Verify(context.Background(), second.sign(t, validClaims())); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("got error %v, want %v", err, ErrInvalidToken)
+	}
+	if fetches := server.fetchCount(); fetches != 1 {
+		t.Fatalf("got %d fetches, want 1", fetches)
+	}
+}
+
+func TestVerifyRefetchesKeys(t *testing.T) {
+	first := newTestKey(t, "key-1")
+	second := newTestKey(t, "key-2")
+	server := newJwksServer(t, first.jwk(t))
+	v, err := New(Options{
+		Issuer:             testIssuer,
+		JwksURL:            server.URL,
+		RefreshInterval:    time.Hour,
+		MinRefreshInterval: time.Nanosecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := v.Verify(context.Background(), first.sign(t, validClaims())); err != nil {
+		t.Fatalf("got error %v, want none", err)
+	}
+
+	// A token signed by a rotated key makes the keys refetched.
+	server.set(http.StatusOK, first.jwk(t), second.jwk(t))
+	if _, err := v.Verify(context.Background(), second.sign(t, validClaims())); err != nil {
+		t.Fatalf("got error %v for a rotated key, want none", err)
+	}
+	if fetches := server.fetchCount(); fetches != 2 {
+		t.Fatalf("got %d fetches, want 2", fetches)
+	}
+
+	// Keys removed from the set are no longer accepted once refetched.
+	server.set(http.StatusOK, second.jwk(t))
+	if _, err := v.Verify(context.Background(), newTestKey(t, "key-3").sign(t, validClaims())); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("got error %v, want %v", err, ErrInvalidToken)
+	}
+	if _, err := v.Verify(context.Background(), first.sign(t, validClaims())); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("got error %v for a removed key, want %v", err, ErrInvalidToken)
+	}
+}
+
+func TestVerifyKeepsKeysWhileServerIsDown(t *testing.T) {
+	key := newTestKey(t, "key-1")
+	server := newJwksServer(t, key.jwk(t))
+	v, err := New(Options{
+		Issuer:             testIssuer,
+		JwksURL:            server.URL,
+		RefreshInterval:    time.Nanosecond,
+		MinRefreshInterval: time.Nanosecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := v.Verify(context.Background(), key.sign(t, validClaims())); err != nil {
+		t.Fatalf("got error %v, want none", err)
+	}
+	server.set(http.StatusInternalServerError)
+	if _, err := v.Verify(context.Background(), key.sign(t, validClaims())); err != nil {
+		t.Fatalf("got error %v with stale keys, want none", err)
+	}
+	if fetches := server.fetchCount(); fetches != 2 {
+		t.Fatalf("got %d fetches, want 2", fetches)
+	}
+}
+
+func TestVerifyChecksRevocation(t *testing.T) {
+	key := newTestKey(t, "key-1")
+	server := newJwksServer(t, key.jwk(t))
+	introspection := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientId, clientSecret, ok := r.BasicAuth()
+		if !ok || clientId != "gateway" || clientSecret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]bool{"active": r.FormValue("token") != "revoked"})
+	}))
+	defer introspection.Close()
+
+	tests := []struct {
+		name       string
+		revocation RevocationChecker
+		err        error
+	}{
+		{
+			name: "not revoked",
+			revocation: RevocationCheckerFunc(func(ctx context.Context, accessToken string, claims *Claims) (bool, error) {
+				return false, nil
+			}),
+		},
+		{
+			name: "revoked",
+			revocation: RevocationCheckerFunc(func(ctx context.Context, accessToken string, claims *Claims) (bool, error) {
+				return claims.Id == "token-id", nil
+			}),
+			err: ErrTokenRevoked,
+		},
+		{name: "active at the introspection endpoint", revocation: NewIntrospectionChecker(introspection.URL, "gateway", "secret", nil)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v, err := New(Options{Issuer: testIssuer, JwksURL: server.URL, Revocation: test.revocation})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = v.Verify(context.Background(), key.sign(t, validClaims()))
+			if !errors.Is(err, test.err) {
+				t.Fatalf("got error %v, want %v", err, test.err)
+			}
+		})
+	}
+
+	revoked, err := NewIntrospectionChecker(introspection.URL, "gateway", "secret", nil).IsRevoked(context.Background(), "revoked", nil)
+	if err != nil || !revoked {
+		t.Fatalf("got revoked %t and error %v, want a revoked token", revoked, err)
+	}
+	if _, err := NewIntrospectionChecker(introspection.URL, "gateway", "guess", nil).IsRevoked(context.Background(), "token", nil); err == nil {
+		t.Fatal("got no error for a client failing to authenticate")
+	}
+}
+
+func TestNewDefaultsJwksURL(t *testing.T) {
+	if _, err := New(Options{Issuer: "jwt-auth-demo"}); err == nil {
+		t.Fatal("got no error for an issuer that is not a URL and no jwks url")
+	}
+	v, err := New(Options{Issuer: testIssuer + "/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url := v.(*verifier).keys.url; url != testIssuer+"/.well-known/jwks.json" {
+		t.Fatalf("got jwks url %q", url)
+	}
+}